@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage:
+  dazuukiknie-agent                        run the tray agent
+  dazuukiknie-agent config validate [path] check config.json (or path) for errors
+  dazuukiknie-agent device                 show the ID and name this device reports as
+  dazuukiknie-agent push                   make the running agent send its sessions now
+  dazuukiknie-agent status                 show what the running agent is tracking
+`
+
+// runCommand handles command-line use of the agent. It reports whether args
+// named a command, in which case the process exits with the returned code
+// instead of starting the tray.
+func runCommand(args []string, stdout, stderr io.Writer) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	switch {
+	case len(args) >= 2 && args[0] == "config" && args[1] == "validate" && len(args) <= 3:
+		path := configPath()
+		if len(args) == 3 {
+			path = args[2]
+		}
+		return true, validateConfigFile(path, stdout, stderr)
+	case len(args) == 1 && args[0] == "device":
+		name := "(not set)"
+		if c, err := readConfig(configPath()); err == nil && c.DeviceName != "" {
+			name = c.DeviceName
+		}
+		fmt.Fprintf(stdout, "id:   %s\nname: %s\n", machineID(), name)
+		return true, 0
+	case len(args) == 1 && (args[0] == "push" || args[0] == "status"):
+		reply, err := sendControl(controlSocketPath(), args[0])
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return true, 1
+		}
+		fmt.Fprintln(stdout, reply)
+		return true, 0
+	case args[0] == "help" || args[0] == "-h" || args[0] == "--help":
+		fmt.Fprint(stdout, usage)
+		return true, 0
+	}
+	fmt.Fprint(stderr, usage)
+	return true, 2
+}
+
+func validateConfigFile(path string, stdout, stderr io.Writer) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	_, from, err := parseConfig(data)
+	if err != nil {
+		// errors.Join separates the field errors with newlines
+		var joined interface{ Unwrap() []error }
+		errs := []error{err}
+		if errors.As(err, &joined) {
+			errs = joined.Unwrap()
+		}
+		for _, e := range errs {
+			fmt.Fprintf(stderr, "%s: %v\n", path, e)
+		}
+		return 1
+	}
+	if from < currentConfigVersion {
+		fmt.Fprintf(stdout, "%s: ok (version %d, will be migrated to %d on next start)\n", path, from, currentConfigVersion)
+		return 0
+	}
+	fmt.Fprintf(stdout, "%s: ok\n", path)
+	return 0
+}
@@ -0,0 +1,12 @@
+package main
+
+import "time"
+
+// Clock abstracts the current time so sessions can be driven by tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
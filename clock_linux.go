@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const clockBoottime = 7 // CLOCK_BOOTTIME
+
+var processStart = time.Now()
+
+// uptime returns the time since boot including time asleep. Unlike the wall
+// clock it is never set, and unlike Go's monotonic clock it keeps running
+// during suspend.
+func uptime() time.Duration {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockBoottime, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return time.Since(processStart)
+	}
+	return time.Duration(ts.Nano())
+}
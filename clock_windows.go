@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "time"
+
+var procGetTickCount64 = kernel32.NewProc("GetTickCount64")
+
+// uptime returns the time since boot including time asleep, which unlike the
+// wall clock is never set.
+func uptime() time.Duration {
+	ms, _, _ := procGetTickCount64.Call()
+	return time.Duration(ms) * time.Millisecond
+}
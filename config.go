@@ -2,28 +2,134 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 )
 
 type GameEntry struct {
 	Process string `json:"process"` // executable name (without .exe on windows)
 	Name    string `json:"name"`
+	// SteamAppID renames a Steam game instead of matching a process.
+	SteamAppID int `json:"steam_app_id,omitempty"`
+	// MinSessionSeconds overrides Config.MinSessionSeconds for this game.
+	// Entries without a process only set the override (e.g. for Steam games).
+	MinSessionSeconds *int `json:"min_session_seconds,omitempty"`
+}
+
+// SinkConfig is one destination sessions are reported to.
+type SinkConfig struct {
+	Name   string `json:"name"` // also names the sink's queue file in the data dir
+	URL    string `json:"url"`
+	Format string `json:"format,omitempty"` // "report" (default), "ndjson" or "template"
+	Token  string `json:"token,omitempty"`  // sent as a bearer token
+	// Template is the text/template for the body of each session's request
+	// when Format is "template".
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// MQTTConfig publishes the current game and daily totals to an MQTT broker.
+type MQTTConfig struct {
+	Broker   string `json:"broker"` // tcp://host:1883 or tls://host:8883; empty disables MQTT
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Topic is the prefix for the agent's topics, dazuukiknie/<machine id> when empty.
+	Topic           string `json:"topic,omitempty"`
+	Discovery       bool   `json:"discovery"` // announce entities to Home Assistant
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+func (m MQTTConfig) topic() string {
+	if m.Topic != "" {
+		return strings.TrimSuffix(m.Topic, "/")
+	}
+	return "dazuukiknie/" + machineID()
+}
+
+// DiscordConfig shows the current game as Discord Rich Presence.
+type DiscordConfig struct {
+	Enabled  bool   `json:"enabled"`
+	ClientID string `json:"client_id"` // Discord application the presence is shown for
+	// Skip lists games that set their own Rich Presence.
+	Skip []string `json:"skip,omitempty"`
 }
 
 type Config struct {
-	ServerURL string      `json:"server_url"`
-	Games     []GameEntry `json:"games"`
+	Version int `json:"version"` // schema version, see configMigrations
+	// DeviceName is reported along with the device ID; empty sends none.
+	DeviceName string `json:"device_name,omitempty"`
+	// Profiles are the people sharing this device; Profile is the one
+	// playing, recorded on each session.
+	Profiles []string     `json:"profiles,omitempty"`
+	Profile  string       `json:"profile,omitempty"`
+	Sinks    []SinkConfig `json:"sinks"`
+	Games    []GameEntry  `json:"games"`
+	// ScanProcesses counts a configured game as running while its process
+	// exists, instead of only while it owns the focused window.
+	ScanProcesses bool `json:"scan_processes"`
+	// MergeGapMinutes stitches sessions of the same game together when the
+	// gap between them (crash, relaunch, alt-tab) is shorter than this.
+	MergeGapMinutes int `json:"merge_gap_minutes"`
+	// MinSessionSeconds drops sessions shorter than this as noise.
+	MinSessionSeconds int `json:"min_session_seconds"`
+	// GamesURL serves the team-wide game list merged with Games; empty (the
+	// default) disables it.
+	GamesURL         string `json:"games_url"`
+	GamesSyncMinutes int    `json:"games_sync_minutes"`
+	// EventsURL receives live start, heartbeat and end events; empty (the
+	// default) disables them. EventsToken is sent as a bearer token.
+	EventsURL        string        `json:"events_url"`
+	EventsToken      string        `json:"events_token,omitempty"`
+	HeartbeatSeconds int           `json:"heartbeat_seconds"`
+	MQTT             MQTTConfig    `json:"mqtt"`
+	Discord          DiscordConfig `json:"discord"`
+	Privacy          PrivacyConfig `json:"privacy"`
+	Network          NetworkConfig `json:"network"`
+	// ReportOnMetered sends sessions on metered connections too, instead of
+	// holding them until an unmetered one.
+	ReportOnMetered bool `json:"report_on_metered"`
 }
 
 func defaultConfig() *Config {
 	return &Config{
-		ServerURL: "https://dazuukiknie.nl/api/sessions",
-		Games:     []GameEntry{},
+		Version:           currentConfigVersion,
+		Sinks:             []SinkConfig{{Name: "dazuukiknie", URL: "https://dazuukiknie.nl/api/sessions"}},
+		Games:             []GameEntry{},
+		ScanProcesses:     true,
+		MergeGapMinutes:   2,
+		MinSessionSeconds: 10,
+		GamesSyncMinutes:  60,
+		HeartbeatSeconds:  60,
+		MQTT:              MQTTConfig{Discovery: true, DiscoveryPrefix: "homeassistant"},
 	}
 }
 
+// sessionPolicy returns the session stitching and filtering rules from the
+// config and the shared game list, local entries taking precedence.
+func (c *Config) sessionPolicy(shared []GameEntry) SessionPolicy {
+	p := SessionPolicy{
+		MergeGap:        time.Duration(c.MergeGapMinutes) * time.Minute,
+		MinDuration:     time.Duration(c.MinSessionSeconds) * time.Second,
+		GameMinDuration: make(map[string]time.Duration),
+	}
+	for _, g := range slices.Concat(shared, c.Games) {
+		if g.MinSessionSeconds != nil {
+			p.GameMinDuration[strings.ToLower(g.Name)] = time.Duration(*g.MinSessionSeconds) * time.Second
+		}
+	}
+	return p
+}
+
+func (c *Config) gamesSyncInterval() time.Duration {
+	return time.Duration(max(c.GamesSyncMinutes, 1)) * time.Minute
+}
+
 func configDir() string {
 	if runtime.GOOS == "windows" {
 		return filepath.Join(os.Getenv("APPDATA"), "dazuukiknie")
@@ -40,8 +146,14 @@ func dataDir() string {
 	return filepath.Join(home, ".local", "share", "dazuukiknie")
 }
 
+func configPath() string {
+	return filepath.Join(configDir(), "config.json")
+}
+
+// loadConfig reads config.json, creating it with defaults on first run and
+// upgrading files written for older schema versions (keeping a backup).
 func loadConfig() (*Config, error) {
-	path := filepath.Join(configDir(), "config.json")
+	path := configPath()
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		cfg := defaultConfig()
@@ -51,11 +163,83 @@ func loadConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+
+	cfg, from, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if from < currentConfigVersion {
+		backup := fmt.Sprintf("%s.v%d.bak", path, from)
+		if err := os.WriteFile(backup, data, 0644); err != nil {
+			log.Printf("Config backup failed (%v), leaving version %d file as is", err, from)
+		} else if err := saveConfig(cfg); err != nil {
+			log.Printf("Config migration save failed: %v", err)
+		} else {
+			log.Printf("Config migrated from version %d to %d, old file kept as %s", from, currentConfigVersion, backup)
+		}
+	}
+	saveLastGoodConfig(cfg)
+	return cfg, nil
+}
+
+// readConfig parses and validates a config file without creating or
+// rewriting it.
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
-	return &cfg, nil
+	cfg, _, err := parseConfig(data)
+	return cfg, err
+}
+
+// saveLastGoodConfig keeps a copy of the last config that loaded cleanly, to
+// fall back on when config.json is broken at startup.
+func saveLastGoodConfig(cfg *Config) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(dataDir(), 0755)
+	if err := os.WriteFile(filepath.Join(dataDir(), "config.last-good.json"), data, 0644); err != nil {
+		log.Printf("Config backup failed: %v", err)
+	}
+}
+
+func loadLastGoodConfig() (*Config, error) {
+	return readConfig(filepath.Join(dataDir(), "config.last-good.json"))
+}
+
+// updateConfig applies change to config.json and saves it, for the watcher to
+// pick up. It fails rather than overwrite a file that doesn't load, which is
+// probably being edited.
+func updateConfig(change func(*Config)) error {
+	c, err := readConfig(configPath())
+	if err != nil {
+		return err
+	}
+	change(c)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return saveConfig(c)
+}
+
+// setGameName names the game with the given process or Steam app ID,
+// updating its entry or adding one.
+func (c *Config) setGameName(process string, steamAppID int, name string) {
+	for i, g := range c.Games {
+		if (steamAppID != 0 && g.SteamAppID == steamAppID) ||
+			(steamAppID == 0 && g.Process != "" && strings.EqualFold(baseNameNoExt(g.Process), baseNameNoExt(process))) {
+			c.Games[i].Name = name
+			return
+		}
+	}
+	if steamAppID != 0 {
+		c.Games = append(c.Games, GameEntry{SteamAppID: steamAppID, Name: name})
+		return
+	}
+	c.Games = append(c.Games, GameEntry{Process: baseNameNoExt(process), Name: name})
 }
 
 func saveConfig(cfg *Config) error {
@@ -63,9 +247,11 @@ func saveConfig(cfg *Config) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	out := *cfg
+	out.Version = currentConfigVersion
+	data, err := json.MarshalIndent(&out, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0644)
+	return os.WriteFile(configPath(), data, 0644)
 }
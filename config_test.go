@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUpdateConfigNamesGames(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	c := defaultConfig()
+	c.Games = []GameEntry{{Process: "factorio", Name: "Factorio"}}
+	if err := saveConfig(c); err != nil {
+		t.Fatal(err)
+	}
+
+	err := updateConfig(func(c *Config) {
+		c.setGameName("Factorio.exe", 0, "Factorio: Space Age")
+		c.setGameName("/usr/bin/rimworld", 0, "RimWorld")
+		c.setGameName("cs2", 730, "CS2")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readConfig(configPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GameEntry{
+		{Process: "factorio", Name: "Factorio: Space Age"},
+		{Process: "rimworld", Name: "RimWorld"},
+		{SteamAppID: 730, Name: "CS2"},
+	}
+	if len(got.Games) != len(want) {
+		t.Fatalf("got games %+v, want %+v", got.Games, want)
+	}
+	for i := range want {
+		if got.Games[i].Process != want[i].Process || got.Games[i].Name != want[i].Name || got.Games[i].SteamAppID != want[i].SteamAppID {
+			t.Errorf("games[%d] = %+v, want %+v", i, got.Games[i], want[i])
+		}
+	}
+
+	// A broken file is left for the user to fix
+	if err := os.WriteFile(configPath(), []byte(`{"games": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateConfig(func(c *Config) {}); err == nil {
+		t.Fatal("broken config overwritten")
+	}
+}
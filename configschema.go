@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// currentConfigVersion is the schema version this agent writes. Files
+// without a version predate versioning and count as version 1.
+const currentConfigVersion = 2
+
+// configMigrations upgrade a raw config document from version n to n+1.
+var configMigrations = map[int]func(raw map[string]any){
+	// 2: server_url became the first of a list of sinks
+	1: func(raw map[string]any) {
+		if u, ok := raw["server_url"].(string); ok {
+			raw["sinks"] = []any{map[string]any{"name": "dazuukiknie", "url": u}}
+		}
+		delete(raw, "server_url")
+	},
+}
+
+var sinkNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ConfigError is a problem with one field of the config file.
+type ConfigError struct {
+	Field string // JSON path, e.g. games[2].process
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// parseConfig decodes, migrates and validates a config document. It also
+// returns the schema version the document was written in.
+func parseConfig(data []byte) (*Config, int, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, jsonError(data, err)
+	}
+	if raw == nil {
+		return nil, 0, &ConfigError{Field: "(root)", Msg: "must be a JSON object"}
+	}
+
+	from, err := migrateConfig(raw)
+	if err != nil {
+		return nil, from, err
+	}
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, from, err
+	}
+
+	// Start from the defaults so options missing from older files keep sane values
+	cfg := defaultConfig()
+	dec := json.NewDecoder(bytes.NewReader(migrated))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, from, jsonError(migrated, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, from, err
+	}
+	return cfg, from, nil
+}
+
+// migrateConfig upgrades raw in place to currentConfigVersion and returns the
+// version it started at.
+func migrateConfig(raw map[string]any) (int, error) {
+	from := 1
+	if v, ok := raw["version"]; ok {
+		f, isNum := v.(float64)
+		if !isNum || f != float64(int(f)) || f < 1 {
+			return 0, &ConfigError{Field: "version", Msg: "must be a positive whole number"}
+		}
+		from = int(f)
+	}
+	if from > currentConfigVersion {
+		return from, &ConfigError{Field: "version", Msg: fmt.Sprintf("%d is newer than this agent supports (%d), please update the agent", from, currentConfigVersion)}
+	}
+	for v := from; v < currentConfigVersion; v++ {
+		configMigrations[v](raw)
+	}
+	raw["version"] = currentConfigVersion
+	return from, nil
+}
+
+// jsonError turns decoding errors into ConfigErrors pointing at the problem.
+func jsonError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// Offset counts the offending byte itself
+		line, col := lineCol(data, syntaxErr.Offset-1)
+		return &ConfigError{Field: fmt.Sprintf("line %d, column %d", line, col), Msg: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		field := fieldPath(typeErr.Field)
+		return &ConfigError{Field: field, Msg: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &ConfigError{Field: name, Msg: "unknown option"}
+	}
+	return err
+}
+
+// fieldPath turns encoding/json's "games.0.name" into "games[0].name".
+func fieldPath(field string) string {
+	if field == "" {
+		return "(root)"
+	}
+	var b strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func lineCol(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := int64(0); i < offset && i < int64(len(data)); i++ {
+		if data[i] == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// validate checks for values the agent can't work with.
+func (c *Config) validate() error {
+	var errs []error
+	add := func(field, format string, args ...any) {
+		errs = append(errs, &ConfigError{Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	for i, p := range c.Profiles {
+		if strings.TrimSpace(p) == "" {
+			add(fmt.Sprintf("profiles[%d]", i), "must not be empty")
+		} else if j := slices.IndexFunc(c.Profiles[:i], func(q string) bool { return strings.EqualFold(p, q) }); j >= 0 {
+			add(fmt.Sprintf("profiles[%d]", i), "%q is already listed at profiles[%d]", p, j)
+		}
+	}
+	if c.Profile != "" && !slices.Contains(c.Profiles, c.Profile) {
+		add("profile", "%q is not one of profiles", c.Profile)
+	}
+
+	sinks := make(map[string]int)
+	for i, s := range c.Sinks {
+		if !sinkNamePattern.MatchString(s.Name) {
+			add(fmt.Sprintf("sinks[%d].name", i), "must be letters, digits, - or _")
+		} else if j, dup := sinks[s.Name]; dup {
+			add(fmt.Sprintf("sinks[%d].name", i), "%q is already used by sinks[%d]", s.Name, j)
+		} else {
+			sinks[s.Name] = i
+		}
+		if err := validateURL(s.URL); err != nil {
+			add(fmt.Sprintf("sinks[%d].url", i), "%v", err)
+		}
+		switch s.Format {
+		case "", "report", "ndjson":
+			if s.Template != "" {
+				add(fmt.Sprintf("sinks[%d].template", i), "only used with format template")
+			}
+		case "template":
+			if strings.TrimSpace(s.Template) == "" {
+				add(fmt.Sprintf("sinks[%d].template", i), "must not be empty")
+			} else if _, err := parseSinkTemplate(s.Template); err != nil {
+				add(fmt.Sprintf("sinks[%d].template", i), "%v", err)
+			}
+		default:
+			add(fmt.Sprintf("sinks[%d].format", i), "unknown format %q, want report, ndjson or template", s.Format)
+		}
+	}
+	if c.GamesURL != "" {
+		if err := validateURL(c.GamesURL); err != nil {
+			add("games_url", "%v", err)
+		}
+	}
+	if c.MQTT.Broker != "" {
+		if u, err := url.Parse(c.MQTT.Broker); err != nil {
+			add("mqtt.broker", "%v", err)
+		} else if !slices.Contains([]string{"tcp", "mqtt", "tls", "ssl", "mqtts"}, u.Scheme) || u.Host == "" {
+			add("mqtt.broker", "%q is not a tcp:// or tls:// broker URL", c.MQTT.Broker)
+		}
+		if strings.ContainsAny(c.MQTT.Topic, "#+") {
+			add("mqtt.topic", "must not contain wildcards")
+		}
+		if c.MQTT.Discovery && c.MQTT.DiscoveryPrefix == "" {
+			add("mqtt.discovery_prefix", "must not be empty with discovery on")
+		}
+		if c.MQTT.Password != "" && c.MQTT.Username == "" {
+			add("mqtt.password", "needs mqtt.username")
+		}
+	}
+	if _, err := proxyFunc(c.Network.Proxy); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildTLSConfig(c.Network); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.Privacy.Process {
+	case "", "keep", "hash", "drop":
+	default:
+		add("privacy.process", "unknown mode %q, want keep, hash or drop", c.Privacy.Process)
+	}
+	if c.Discord.Enabled && strings.TrimSpace(c.Discord.ClientID) == "" {
+		add("discord.client_id", "must not be empty with discord enabled")
+	}
+	if c.EventsURL != "" {
+		if err := validateURL(c.EventsURL); err != nil {
+			add("events_url", "%v", err)
+		}
+	}
+
+	seen := make(map[string]int)
+	steamApps := make(map[int]int)
+	for i, g := range c.Games {
+		if strings.TrimSpace(g.Name) == "" {
+			add(fmt.Sprintf("games[%d].name", i), "must not be empty")
+		}
+		if g.SteamAppID < 0 {
+			add(fmt.Sprintf("games[%d].steam_app_id", i), "must not be negative")
+		} else if g.SteamAppID > 0 {
+			if j, dup := steamApps[g.SteamAppID]; dup {
+				add(fmt.Sprintf("games[%d].steam_app_id", i), "%d is already listed at games[%d]", g.SteamAppID, j)
+			} else {
+				steamApps[g.SteamAppID] = i
+			}
+		}
+		if strings.TrimSpace(g.Process) == "" {
+			if g.MinSessionSeconds == nil && g.SteamAppID == 0 {
+				add(fmt.Sprintf("games[%d].process", i), "must not be empty")
+			}
+		} else {
+			key := strings.ToLower(baseNameNoExt(g.Process))
+			if j, dup := seen[key]; dup {
+				add(fmt.Sprintf("games[%d].process", i), "%q is already listed at games[%d]", g.Process, j)
+			} else {
+				seen[key] = i
+			}
+		}
+		if g.MinSessionSeconds != nil && *g.MinSessionSeconds < 0 {
+			add(fmt.Sprintf("games[%d].min_session_seconds", i), "must not be negative")
+		}
+	}
+
+	if c.MergeGapMinutes < 0 {
+		add("merge_gap_minutes", "must not be negative")
+	}
+	if c.MinSessionSeconds < 0 {
+		add("min_session_seconds", "must not be negative")
+	}
+	if c.GamesSyncMinutes < 0 {
+		add("games_sync_minutes", "must not be negative")
+	}
+	if c.HeartbeatSeconds < 0 {
+		add("heartbeat_seconds", "must not be negative")
+	}
+	return errors.Join(errs...)
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []string // one entry per expected error
+	}{
+		{
+			name: "syntax error position",
+			json: "{\n  \"server_url\": \"https://example.com\",\n  \"games\": [,]\n}",
+			want: []string{"line 3, column 13"},
+		},
+		{
+			name: "unknown option",
+			json: `{"version": 1, "server_url": "https://example.com", "merge_gap": 5}`,
+			want: []string{"merge_gap: unknown option"},
+		},
+		{
+			name: "wrong type",
+			json: `{"version": 1, "server_url": "https://example.com", "games": [{"process": "x", "name": "X", "min_session_seconds": "ten"}]}`,
+			want: []string{"games[0].min_session_seconds: expected int"},
+		},
+		{
+			name: "newer version",
+			json: `{"version": 99, "server_url": "https://example.com"}`,
+			want: []string{"version: 99 is newer"},
+		},
+		{
+			name: "profiles",
+			json: `{"version": 2, "profiles": ["Anna", "anna"], "profile": "Ben"}`,
+			want: []string{
+				`profiles[1]: "anna" is already listed at profiles[0]`,
+				`profile: "Ben" is not one of profiles`,
+			},
+		},
+		{
+			name: "mqtt password without username",
+			json: `{"version": 2, "mqtt": {"broker": "tcp://localhost:1883", "password": "secret"}}`,
+			want: []string{"mqtt.password: needs mqtt.username"},
+		},
+		{
+			name: "field errors",
+			json: `{"version": 2, "sinks": [{"name": "a b", "url": "ftp://example.com"}, {"name": "x", "url": "https://example.com", "format": "xml"},
+				{"name": "hook", "url": "https://example.com", "format": "template", "template": "{{.Game"}], "merge_gap_minutes": -1,
+				"games": [{"process": "factorio", "name": "Factorio"}, {"process": "Factorio.exe", "name": ""}]}`,
+			want: []string{
+				"sinks[0].name: must be letters, digits, - or _",
+				`sinks[0].url: "ftp://example.com" is not an http(s) URL`,
+				`sinks[1].format: unknown format "xml"`,
+				"sinks[2].template: template: body:1: unclosed action",
+				"games[1].name: must not be empty",
+				`games[1].process: "Factorio.exe" is already listed at games[0]`,
+				"merge_gap_minutes: must not be negative",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseConfig([]byte(tt.json))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("got errors:\n%v\nwant %d", err, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigMigrates(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	t.Setenv("LOCALAPPDATA", home)
+
+	v1 := `{"server_url": "https://example.com/api", "games": [{"process": "Game.EXE", "name": "Game"}], "merge_gap_minutes": 5}`
+	if err := os.MkdirAll(configDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath(), []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != currentConfigVersion || c.Games[0].Process != "Game.EXE" || c.MergeGapMinutes != 5 ||
+		len(c.Sinks) != 1 || c.Sinks[0].URL != "https://example.com/api" {
+		t.Errorf("unexpected migrated config %+v", c)
+	}
+
+	backup, err := os.ReadFile(configPath() + ".v1.bak")
+	if err != nil || string(backup) != v1 {
+		t.Errorf("backup not kept: %q, %v", backup, err)
+	}
+	var saved Config
+	data, _ := os.ReadFile(configPath())
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Version != currentConfigVersion {
+		t.Errorf("migrated config not written back: %s", data)
+	}
+
+	// A broken config.json leaves the last working copy to fall back on
+	if err := os.WriteFile(configPath(), []byte(`{"server_url": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(); err == nil {
+		t.Fatal("broken config accepted")
+	}
+	last, err := loadLastGoodConfig()
+	if err != nil || last.Sinks[0].URL != "https://example.com/api" {
+		t.Errorf("last good config %+v, %v", last, err)
+	}
+}
+
+func TestLoadConfigKeepsFileWithoutBackup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	t.Setenv("LOCALAPPDATA", home)
+
+	v1 := `{"server_url": "https://example.com/api"}`
+	if err := os.MkdirAll(configPath()+".v1.bak", 0755); err != nil {
+		t.Fatal(err) // a directory in the way of the backup
+	}
+	if err := os.WriteFile(configPath(), []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig()
+	if err != nil || c.Sinks[0].URL != "https://example.com/api" {
+		t.Fatalf("config %+v, %v", c, err)
+	}
+	if data, _ := os.ReadFile(configPath()); string(data) != v1 {
+		t.Errorf("config.json rewritten without a backup: %s", data)
+	}
+
+	c.Version = 1
+	if err := saveConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 1 {
+		t.Errorf("saveConfig changed the caller's version to %d", c.Version)
+	}
+}
+
+func TestConfigValidateCommand(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(good, []byte(`{"server_url": "https://example.com"}`), 0644)
+	os.WriteFile(bad, []byte(`{"version": 1, "server_url": "x", "games": [{"name": "X"}]}`), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if handled, code := runCommand([]string{"config", "validate", good}, &stdout, &stderr); !handled || code != 0 {
+		t.Fatalf("good config: handled %v, code %d, stderr %q", handled, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "will be migrated to") {
+		t.Errorf("unversioned config not flagged for migration: %q", stdout.String())
+	}
+
+	stderr.Reset()
+	if _, code := runCommand([]string{"config", "validate", bad}, &stdout, &stderr); code != 1 {
+		t.Fatalf("bad config: code %d", code)
+	}
+	if got := strings.Count(stderr.String(), bad+": "); got != 2 {
+		t.Errorf("want 2 errors, got %q", stderr.String())
+	}
+
+	if handled, _ := runCommand(nil, &stdout, &stderr); handled {
+		t.Error("no arguments should start the agent")
+	}
+}
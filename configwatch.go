@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// runConfigWatcher reloads the config file whenever it changes on disk and
+// hands the result to apply: the new config, or why it was rejected. A
+// rejected file leaves the running config untouched.
+func runConfigWatcher(ctx context.Context, apply func(*Config, error)) {
+	watchConfig(ctx, configPath(), func() {}, apply)
+}
+
+// watchConfig is runConfigWatcher for path, calling ready once it watches.
+func watchConfig(ctx context.Context, path string, ready func(), apply func(*Config, error)) {
+	changes := make(chan struct{}, 1)
+	go func() {
+		err := watchFile(ctx, path, ready, func() {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			log.Printf("Config watch failed: %v", err)
+		}
+	}()
+
+	// Editors write in several steps; wait for the file to settle
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			settle = time.After(300 * time.Millisecond)
+		case <-settle:
+			settle = nil
+			next, err := readConfig(path)
+			if os.IsNotExist(err) {
+				continue // mid-save, or deleted: keep what we have
+			}
+			apply(next, err)
+		}
+	}
+}
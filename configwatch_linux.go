@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile calls changed whenever path is written or replaced. It watches
+// the directory, since editors often save by renaming a new file into place.
+// ready is called once changes are being watched.
+func watchFile(ctx context.Context, path string, ready, changed func()) error {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %w", err)
+	}
+	// Non-blocking, so reads go through the runtime poller and Close unblocks them
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		return fmt.Errorf("inotify watch %s: %w", dir, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	ready()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			if string(bytes.TrimRight(nameBytes, "\x00")) == name {
+				changed()
+			}
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type reload struct {
+	cfg *Config
+	err error
+}
+
+func TestConfigWatcherReloads(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	if err := saveConfig(defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan reload, 4)
+	ready := make(chan struct{})
+	go watchConfig(ctx, configPath(), func() { close(ready) }, func(c *Config, err error) { reloads <- reload{c, err} })
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config watch not started")
+	}
+
+	// Editors commonly save by renaming a temp file over the original
+	writeAtomic(t, configPath(), `{"server_url": "https://example.com/api", "games": [{"process": "factorio", "name": "Factorio"}]}`)
+	r := waitReload(t, reloads)
+	if r.err != nil {
+		t.Fatalf("valid config rejected: %v", r.err)
+	}
+	if len(r.cfg.Sinks) != 1 || r.cfg.Sinks[0].URL != "https://example.com/api" || len(r.cfg.Games) != 1 {
+		t.Errorf("unexpected config %+v", r.cfg)
+	}
+	if r.cfg.MinSessionSeconds != 10 {
+		t.Errorf("missing option not defaulted: min_session_seconds %d", r.cfg.MinSessionSeconds)
+	}
+
+	writeAtomic(t, configPath(), `{"server_url": "not a url", "games": [{"process": "", "name": "x"}]}`)
+	if r := waitReload(t, reloads); r.err == nil {
+		t.Fatal("invalid config accepted")
+	}
+
+	writeAtomic(t, configPath(), `{"server_url": `)
+	if r := waitReload(t, reloads); r.err == nil {
+		t.Fatal("malformed config accepted")
+	}
+}
+
+func writeAtomic(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := filepath.Join(filepath.Dir(path), ".config.json.tmp")
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitReload(t *testing.T, reloads <-chan reload) reload {
+	t.Helper()
+	select {
+	case r := <-reloads:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("config not reloaded")
+		return reload{}
+	}
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchFile calls changed whenever path is written or replaced, by polling
+// its modification time. ready is called once changes are being watched.
+func watchFile(ctx context.Context, path string, ready, changed func()) error {
+	stamp := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	lastMod, lastSize := stamp()
+	ready()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			mod, size := stamp()
+			if !mod.Equal(lastMod) || size != lastSize {
+				lastMod, lastSize = mod, size
+				changed()
+			}
+		}
+	}
+}
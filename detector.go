@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,10 +15,53 @@ type DetectedGame struct {
 	Source     string // "steam" | "config"
 	SteamAppID int
 	Process    string
+	PIDs       []int // processes belonging to a Steam game, main process first
+	Focused    bool  // the game owns the foreground window
 }
 
-var steamCache struct {
-	sync.Mutex
+// game returns the session record of the detected game.
+func (g *DetectedGame) game() Game {
+	return Game{
+		Name:       g.Name,
+		Source:     g.Source,
+		SteamAppID: g.SteamAppID,
+		Process:    g.Process,
+	}
+}
+
+// SteamApp is a running Steam game and the processes that belong to it.
+type SteamApp struct {
+	AppID   int
+	Process string // executable of the game's main process
+	PIDs    []int  // every process carrying the app ID, main process first
+}
+
+// ProcessProvider exposes the process table and window state games are detected from.
+type ProcessProvider interface {
+	// SteamRunningApp returns the running Steam game.
+	SteamRunningApp() (SteamApp, error)
+	// RunningProcesses returns the names of the user's running processes.
+	RunningProcesses() ([]string, error)
+	// ActiveWindow returns the PID, process name and title of the focused window.
+	ActiveWindow() (int, string, string, error)
+}
+
+// systemProcesses reads the real process table of this machine.
+type systemProcesses struct{}
+
+func (systemProcesses) SteamRunningApp() (SteamApp, error)         { return getSteamRunningApp() }
+func (systemProcesses) RunningProcesses() ([]string, error)        { return getRunningProcesses() }
+func (systemProcesses) ActiveWindow() (int, string, string, error) { return getActiveWindowInfo() }
+
+// SteamCatalog resolves Steam app IDs to game names.
+type SteamCatalog interface {
+	GameName(appID int) (string, error)
+}
+
+// steamStore looks up game names via the Steam Store API, cached for a day.
+type steamStore struct {
+	clock   Clock
+	mu      sync.Mutex
 	entries map[int]steamCacheEntry
 }
 
@@ -27,21 +70,22 @@ type steamCacheEntry struct {
 	fetchedAt time.Time
 }
 
-func init() {
-	steamCache.entries = make(map[int]steamCacheEntry)
+func newSteamStore(clock Clock) *steamStore {
+	return &steamStore{clock: clock, entries: make(map[int]steamCacheEntry)}
 }
 
-func lookupSteamGame(appID int) (string, error) {
-	steamCache.Lock()
-	if e, ok := steamCache.entries[appID]; ok && time.Since(e.fetchedAt) < 24*time.Hour {
-		steamCache.Unlock()
+var steamClient = newHTTPClient(5 * time.Second)
+
+func (s *steamStore) GameName(appID int) (string, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[appID]; ok && s.clock.Now().Sub(e.fetchedAt) < 24*time.Hour {
+		s.mu.Unlock()
 		return e.name, nil
 	}
-	steamCache.Unlock()
+	s.mu.Unlock()
 
 	url := fmt.Sprintf("https://store.steampowered.com/api/appdetails?appids=%d&filters=basic", appID)
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(url)
+	resp, err := steamClient.Get(url)
 	if err != nil {
 		return "", err
 	}
@@ -64,39 +108,112 @@ func lookupSteamGame(appID int) (string, error) {
 	}
 
 	name := entry.Data.Name
-	steamCache.Lock()
-	steamCache.entries[appID] = steamCacheEntry{name: name, fetchedAt: time.Now()}
-	steamCache.Unlock()
+	s.mu.Lock()
+	s.entries[appID] = steamCacheEntry{name: name, fetchedAt: s.clock.Now()}
+	s.mu.Unlock()
 
 	return name, nil
 }
 
+// Detector finds the game being played from the process table and config.
+type Detector struct {
+	procs  ProcessProvider
+	steam  SteamCatalog
+	shared *GameSync // team-wide game list, may be nil
+}
+
+func newDetector(clock Clock, shared *GameSync) *Detector {
+	return &Detector{procs: systemProcesses{}, steam: newSteamStore(clock), shared: shared}
+}
+
 // Detect returns the currently detected game, or nil if nothing is running.
-func Detect(cfg *Config) *DetectedGame {
+// current is the game being tracked, preferred while it keeps running in the
+// background.
+func (d *Detector) Detect(cfg *Config, current string) *DetectedGame {
 	// 1. Steam: scan processes for SteamAppId environment variable
-	appID, process, err := getSteamRunningApp()
-	if err == nil && appID > 0 {
-		name, err := lookupSteamGame(appID)
-		if err != nil {
-			log.Printf("Steam API lookup failed for %d: %v", appID, err)
-			name = fmt.Sprintf("Steam App %d", appID)
+	// Excluded games must not hide an allowed one running next to them
+	pid, procName, _, windowErr := d.procs.ActiveWindow()
+	app, err := d.procs.SteamRunningApp()
+	if err == nil && app.AppID > 0 {
+		appID := app.AppID
+		name := steamGameName(cfg.Games, appID)
+		if name == "" {
+			name, err = d.steam.GameName(appID)
+			if err != nil {
+				log.Printf("Steam API lookup failed for %d: %v", appID, err)
+				name = fmt.Sprintf("Steam App %d", appID)
+			}
 		}
-		return &DetectedGame{
-			Name:       name,
-			Source:     "steam",
-			SteamAppID: appID,
-			Process:    process,
+		if !cfg.Privacy.excluded(name) {
+			return &DetectedGame{
+				Name:       name,
+				Source:     "steam",
+				SteamAppID: appID,
+				Process:    app.Process,
+				PIDs:       app.PIDs,
+				// Only measurable where the game's PIDs are known
+				Focused: pid > 0 && slices.Contains(app.PIDs, pid),
+			}
 		}
 	}
 
 	// 2. Active window: match process name against user config
-	procName, _, err := getActiveWindowInfo()
-	if err != nil || procName == "" {
+	var games []GameEntry
+	for _, g := range mergeGames(cfg.Games, d.shared.Games()) {
+		if !cfg.Privacy.excluded(g.Name) {
+			games = append(games, g)
+		}
+	}
+	if windowErr == nil && procName != "" {
+		if g := matchConfigGame(games, procName); g != nil {
+			g.Focused = true
+			return g
+		}
+	}
+
+	// 3. Running processes: a configured game counts while it runs in the background
+	if !cfg.ScanProcesses {
+		return nil
+	}
+	procs, err := d.procs.RunningProcesses()
+	if err != nil {
+		log.Printf("Process scan failed: %v", err)
 		return nil
 	}
+	running := make(map[string]string, len(procs))
+	for _, p := range procs {
+		running[strings.ToLower(baseNameNoExt(p))] = p
+	}
+	var found *DetectedGame
+	for _, g := range games {
+		if p, ok := running[strings.ToLower(baseNameNoExt(g.Process))]; ok && g.Process != "" {
+			if g.Name == current {
+				return matchConfigGame(games, p)
+			}
+			if found == nil {
+				found = matchConfigGame(games, p)
+			}
+		}
+	}
+	return found
+}
 
-	for _, g := range cfg.Games {
-		if strings.EqualFold(g.Process, procName) {
+// steamGameName returns the configured name for a Steam game, or "".
+func steamGameName(games []GameEntry, appID int) string {
+	for _, g := range games {
+		if g.SteamAppID == appID && g.Name != "" {
+			return g.Name
+		}
+	}
+	return ""
+}
+
+// matchConfigGame returns the configured game for a process name, or nil.
+// A ".exe" suffix on either side is ignored.
+func matchConfigGame(games []GameEntry, procName string) *DetectedGame {
+	want := baseNameNoExt(procName)
+	for _, g := range games {
+		if g.Process != "" && strings.EqualFold(baseNameNoExt(g.Process), want) {
 			return &DetectedGame{
 				Name:    g.Name,
 				Source:  "config",
@@ -104,6 +221,23 @@ func Detect(cfg *Config) *DetectedGame {
 			}
 		}
 	}
-
 	return nil
 }
+
+// baseNameNoExt strips the directory (either slash style) and a .exe suffix.
+func baseNameNoExt(path string) string {
+	// Find last backslash or forward slash
+	last := -1
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '\\' || path[i] == '/' {
+			last = i
+			break
+		}
+	}
+	name := path[last+1:]
+	// Strip .exe
+	if len(name) > 4 && strings.EqualFold(name[len(name)-4:], ".exe") {
+		name = name[:len(name)-4]
+	}
+	return name
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// deviceIdentity is stored in the data directory as device.json. Copy the
+// file along when moving the agent to keep reporting as the same device.
+type deviceIdentity struct {
+	ID string `json:"id"`
+	// LegacyID is the hostname + username hash used before device IDs. It is
+	// reported to each sink once, so the server can link the old history.
+	LegacyID string   `json:"legacy_id,omitempty"`
+	Linked   []string `json:"linked,omitempty"` // sinks that got LegacyID
+	// HashSalt salts hashed process names. Unlike ID it is never sent, so
+	// the hashes can't be matched against a list of known names.
+	HashSalt string `json:"hash_salt,omitempty"`
+}
+
+type deviceStore struct {
+	path string
+	mu   sync.Mutex
+	deviceIdentity
+}
+
+// device returns this machine's identity, generating it on first use. It is
+// first called before config.json is created, so an existing config means
+// the agent ran before under its legacy ID.
+var device = sync.OnceValue(func() *deviceStore {
+	_, err := os.Stat(configPath())
+	return openDevice(filepath.Join(dataDir(), "device.json"), err == nil)
+})
+
+func openDevice(path string, upgrading bool) *deviceStore {
+	d := &deviceStore{path: path}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &d.deviceIdentity); err == nil && d.ID != "" {
+			if d.HashSalt == "" {
+				d.HashSalt = randomHex(16)
+				d.save()
+			}
+			return d
+		}
+		log.Printf("device.json is damaged, generating a new device ID")
+	} else if !os.IsNotExist(err) {
+		log.Printf("device load: %v", err)
+	}
+
+	d.ID, d.HashSalt = randomHex(8), randomHex(16)
+	if upgrading {
+		d.LegacyID = legacyMachineID()
+	}
+	d.save()
+	log.Printf("Generated device ID %s", d.ID)
+	return d
+}
+
+// previousID returns the legacy ID while sink hasn't been sent it yet.
+func (d *deviceStore) previousID(sink string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.LegacyID == "" || slices.Contains(d.Linked, sink) {
+		return ""
+	}
+	return d.LegacyID
+}
+
+// markLinked records that sink accepted a report carrying the legacy ID.
+func (d *deviceStore) markLinked(sink string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.LegacyID == "" || slices.Contains(d.Linked, sink) {
+		return
+	}
+	d.Linked = append(d.Linked, sink)
+	d.save()
+}
+
+// save must be called with d.mu held, or before d is shared.
+func (d *deviceStore) save() {
+	data, err := json.MarshalIndent(d.deviceIdentity, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(filepath.Dir(d.path), 0755)
+	if err := os.WriteFile(d.path, data, 0600); err != nil {
+		log.Printf("device write: %v", err)
+	}
+}
+
+// machineID returns the random ID this device reports as.
+func machineID() string {
+	return device().ID
+}
+
+// hashSalt returns the secret that hashed process names are salted with.
+func hashSalt() string {
+	return device().HashSalt
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// legacyMachineID is the identifier agents derived from hostname + username
+// before device IDs.
+func legacyMachineID() string {
+	hostname, _ := os.Hostname()
+	username := os.Getenv("USER")
+	if username == "" {
+		username = os.Getenv("USERNAME") // Windows
+	}
+	h := sha256.Sum256([]byte(hostname + username))
+	return fmt.Sprintf("%x", h[:8])
+}
+
+// deviceName is the name shown for this device in Home Assistant and the like.
+func deviceName(c *Config) string {
+	if c.DeviceName != "" {
+		return c.DeviceName
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeviceIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.json")
+
+	d := openDevice(path, true)
+	if len(d.ID) != 16 || d.ID == legacyMachineID() {
+		t.Fatalf("unexpected device ID %q", d.ID)
+	}
+	if got := d.previousID("dazuukiknie"); got != legacyMachineID() {
+		t.Fatalf("previous ID %q, want the legacy ID", got)
+	}
+	d.markLinked("dazuukiknie")
+
+	// The ID and the sinks already linked survive a restart
+	reopened := openDevice(path, true)
+	if reopened.ID != d.ID {
+		t.Errorf("device ID changed from %s to %s", d.ID, reopened.ID)
+	}
+	if len(d.HashSalt) != 32 || reopened.HashSalt != d.HashSalt {
+		t.Errorf("hash salt %q not kept across restarts (%q)", d.HashSalt, reopened.HashSalt)
+	}
+	if got := reopened.previousID("dazuukiknie"); got != "" {
+		t.Errorf("legacy ID %q sent again to a linked sink", got)
+	}
+	if got := reopened.previousID("dashboard"); got != legacyMachineID() {
+		t.Errorf("legacy ID not sent to a new sink: %q", got)
+	}
+
+	if fresh := openDevice(filepath.Join(t.TempDir(), "device.json"), false); fresh.previousID("dazuukiknie") != "" {
+		t.Error("fresh install reports a legacy ID")
+	}
+}
+
+func TestDeviceHashSaltAdded(t *testing.T) {
+	// device.json from before hash salts gets one, keeping its ID
+	path := filepath.Join(t.TempDir(), "device.json")
+	if err := os.WriteFile(path, []byte(`{"id": "a1b2c3d4e5f6a7b8"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := openDevice(path, false)
+	if d.ID != "a1b2c3d4e5f6a7b8" || d.HashSalt == "" {
+		t.Fatalf("got ID %q, salt %q", d.ID, d.HashSalt)
+	}
+	if reopened := openDevice(path, false); reopened.HashSalt != d.HashSalt {
+		t.Error("hash salt not saved")
+	}
+}
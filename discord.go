@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Discord IPC opcodes.
+const (
+	discordHandshake = 0
+	discordFrame     = 1
+	discordClose     = 2
+	discordPing      = 3
+	discordPong      = 4
+)
+
+// DiscordPresence shows the game being played as Discord Rich Presence,
+// through the IPC socket of the locally running Discord client.
+type DiscordPresence struct {
+	clock   Clock
+	dial    func() (io.ReadWriteCloser, error)
+	changed chan struct{}
+
+	mu      sync.Mutex
+	current *DetectedGame
+	since   time.Time
+}
+
+type discordActivity struct {
+	Details    string            `json:"details"`
+	State      string            `json:"state,omitempty"`
+	Timestamps discordTimestamps `json:"timestamps"`
+	Assets     *discordAssets    `json:"assets,omitempty"`
+}
+
+type discordTimestamps struct {
+	Start int64 `json:"start"` // unix seconds
+}
+
+type discordAssets struct {
+	LargeImage string `json:"large_image,omitempty"`
+	LargeText  string `json:"large_text,omitempty"`
+}
+
+func newDiscordPresence(clock Clock) *DiscordPresence {
+	return &DiscordPresence{clock: clock, dial: openDiscordSocket, changed: make(chan struct{}, 1)}
+}
+
+// GameChanged is called by the tracker, with nil when nothing is played.
+func (p *DiscordPresence) GameChanged(g *DetectedGame) {
+	p.mu.Lock()
+	p.current, p.since = g, p.clock.Now()
+	p.mu.Unlock()
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// activity returns the presence to show, nil to clear it.
+func (p *DiscordPresence) activity(dc DiscordConfig) *discordActivity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g := p.current
+	if g == nil || containsFold(dc.Skip, g.Name) {
+		return nil
+	}
+	a := &discordActivity{Details: g.Name, Timestamps: discordTimestamps{Start: p.since.Unix()}}
+	if g.SteamAppID != 0 {
+		a.State = "via Steam"
+		a.Assets = &discordAssets{
+			LargeImage: fmt.Sprintf("https://cdn.cloudflare.steamstatic.com/steam/apps/%d/header.jpg", g.SteamAppID),
+			LargeText:  g.Name,
+		}
+	}
+	return a
+}
+
+// runDiscord keeps Discord's presence in sync with the current game while
+// Discord is enabled in the config and running.
+func runDiscord(ctx context.Context, p *DiscordPresence) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	var conn *discordConn
+	var shown []byte // last activity sent, as JSON
+	disconnect := func() {
+		if conn != nil {
+			_ = conn.setActivity(nil)
+			conn.Close()
+			conn, shown = nil, nil
+		}
+	}
+	defer disconnect()
+
+	for {
+		dc := cfg.Load().Discord
+		if !dc.Enabled || dc.ClientID == "" {
+			disconnect()
+		} else {
+			if conn != nil && conn.clientID != dc.ClientID {
+				disconnect()
+			}
+			if conn == nil {
+				var err error
+				// Not running Discord is normal; retry quietly on the next tick
+				if conn, err = dialDiscord(p.dial, dc.ClientID); err == nil {
+					log.Printf("Connected to Discord")
+				}
+			}
+			if conn != nil {
+				a := p.activity(dc)
+				data, _ := json.Marshal(a)
+				if !bytes.Equal(data, shown) {
+					if err := conn.setActivity(a); err != nil {
+						log.Printf("Discord presence failed: %v", err)
+						conn.Close()
+						conn, shown = nil, nil
+					} else {
+						shown = data
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+type discordConn struct {
+	rw       io.ReadWriteCloser
+	clientID string
+	nonce    int
+}
+
+func dialDiscord(dial func() (io.ReadWriteCloser, error), clientID string) (*discordConn, error) {
+	rw, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	c := &discordConn{rw: rw, clientID: clientID}
+	if err := c.handshake(); err != nil {
+		rw.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *discordConn) handshake() error {
+	if err := c.write(discordHandshake, map[string]any{"v": 1, "client_id": c.clientID}); err != nil {
+		return err
+	}
+	var ready struct {
+		Evt string `json:"evt"`
+	}
+	if err := c.read(&ready); err != nil {
+		return fmt.Errorf("discord handshake: %w", err)
+	}
+	if ready.Evt != "READY" {
+		return fmt.Errorf("discord handshake: got %q instead of READY", ready.Evt)
+	}
+	return nil
+}
+
+// setActivity sets the presence, or clears it when a is nil.
+func (c *discordConn) setActivity(a *discordActivity) error {
+	c.nonce++
+	nonce := fmt.Sprint(c.nonce)
+	err := c.write(discordFrame, map[string]any{
+		"cmd":   "SET_ACTIVITY",
+		"args":  map[string]any{"pid": os.Getpid(), "activity": a},
+		"nonce": nonce,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		var reply struct {
+			Evt   string `json:"evt"`
+			Nonce string `json:"nonce"`
+			Data  struct {
+				Message string `json:"message"`
+			} `json:"data"`
+		}
+		if err := c.read(&reply); err != nil {
+			return err
+		}
+		if reply.Nonce != nonce {
+			continue
+		}
+		if reply.Evt == "ERROR" {
+			return fmt.Errorf("discord: %s", reply.Data.Message)
+		}
+		return nil
+	}
+}
+
+func (c *discordConn) Close() error {
+	return c.rw.Close()
+}
+
+func (c *discordConn) write(op uint32, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, op)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(data)))
+	_, err = c.rw.Write(append(frame, data...))
+	return err
+}
+
+// read decodes the next data frame into v, answering pings on the way.
+func (c *discordConn) read(v any) error {
+	if d, ok := c.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		_ = d.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+			return err
+		}
+		op, n := binary.LittleEndian.Uint32(hdr[0:]), binary.LittleEndian.Uint32(hdr[4:])
+		if n > 1<<20 {
+			return errors.New("discord frame too large")
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return err
+		}
+		switch op {
+		case discordFrame:
+			return json.Unmarshal(data, v)
+		case discordPing:
+			if err := c.write(discordPong, json.RawMessage(data)); err != nil {
+				return err
+			}
+		case discordClose:
+			return fmt.Errorf("discord closed the connection: %s", data)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// openDiscordSocket connects to the first Discord IPC socket found, including
+// those of the Flatpak and Snap packages.
+func openDiscordSocket() (io.ReadWriteCloser, error) {
+	var dirs []string
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		if dir := os.Getenv(env); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	dirs = append(dirs, "/tmp")
+
+	for _, dir := range dirs {
+		for _, sub := range []string{"", "app/com.discordapp.Discord", "snap.discord"} {
+			for i := range 10 {
+				path := filepath.Join(dir, sub, fmt.Sprintf("discord-ipc-%d", i))
+				if conn, err := net.Dial("unix", path); err == nil {
+					return conn, nil
+				}
+			}
+		}
+	}
+	return nil, errors.New("discord is not running")
+}
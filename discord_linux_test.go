@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDiscord serves the Discord IPC protocol and reports every activity set.
+func fakeDiscord(t *testing.T) <-chan *discordActivity {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	ln, err := net.Listen("unix", filepath.Join(dir, "discord-ipc-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	activities := make(chan *discordActivity, 8)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		write := func(op uint32, v any) {
+			data, _ := json.Marshal(v)
+			hdr := binary.LittleEndian.AppendUint32(nil, op)
+			hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(data)))
+			conn.Write(append(hdr, data...))
+		}
+		for {
+			var hdr [8]byte
+			if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			var msg struct {
+				ClientID string `json:"client_id"`
+				Cmd      string `json:"cmd"`
+				Nonce    string `json:"nonce"`
+				Args     struct {
+					Activity *discordActivity `json:"activity"`
+				} `json:"args"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("bad frame %s", data)
+				return
+			}
+			switch binary.LittleEndian.Uint32(hdr[:]) {
+			case discordHandshake:
+				if msg.ClientID != "1234" {
+					t.Errorf("handshake with client id %q", msg.ClientID)
+				}
+				// Discord pings at will; the client has to answer in between
+				write(discordPing, map[string]any{})
+				write(discordFrame, map[string]any{"cmd": "DISPATCH", "evt": "READY"})
+			case discordFrame:
+				if msg.Cmd != "SET_ACTIVITY" {
+					t.Errorf("unexpected command %q", msg.Cmd)
+				}
+				write(discordFrame, map[string]any{"cmd": msg.Cmd, "nonce": msg.Nonce})
+				activities <- msg.Args.Activity
+			}
+		}
+	}()
+	return activities
+}
+
+func TestDiscordPresence(t *testing.T) {
+	activities := fakeDiscord(t)
+	c := defaultConfig()
+	c.Discord = DiscordConfig{Enabled: true, ClientID: "1234", Skip: []string{"rimworld"}}
+	prev := cfg.Load()
+	t.Cleanup(func() { cfg.Store(prev) })
+	cfg.Store(c)
+
+	clock := newFakeClock()
+	p := newDiscordPresence(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runDiscord(ctx, p)
+		close(done)
+	}()
+
+	next := func() *discordActivity {
+		t.Helper()
+		select {
+		case a := <-activities:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an activity")
+			return nil
+		}
+	}
+
+	if a := next(); a != nil {
+		t.Fatalf("presence %+v set while not playing", a)
+	}
+	p.GameChanged(&DetectedGame{Name: "Counter-Strike 2", Source: "steam", SteamAppID: 730})
+	a := next()
+	if a == nil || a.Details != "Counter-Strike 2" || a.Timestamps.Start != testEpoch.Unix() || a.Assets == nil {
+		t.Fatalf("unexpected presence %+v", a)
+	}
+
+	// RimWorld sets its own presence
+	p.GameChanged(&DetectedGame{Name: "RimWorld", Source: "config"})
+	if a := next(); a != nil {
+		t.Fatalf("presence %+v set for a skipped game", a)
+	}
+
+	cancel()
+	<-done
+}
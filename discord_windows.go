@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// openDiscordSocket opens the first Discord IPC named pipe found.
+func openDiscordSocket() (io.ReadWriteCloser, error) {
+	for i := range 10 {
+		if f, err := os.OpenFile(fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, i), os.O_RDWR, 0); err == nil {
+			return f, nil
+		}
+	}
+	return nil, errors.New("discord is not running")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a live update about the game being played.
+type Event struct {
+	Type       string    `json:"type"` // "start" | "heartbeat" | "end"
+	SessionID  string    `json:"session_id"`
+	MachineID  string    `json:"machine_id"`
+	DeviceName string    `json:"device_name,omitempty"`
+	Game       Game      `json:"game"`
+	PIDs       []int     `json:"pids,omitempty"` // a Steam game's processes, main process first
+	StartedAt  time.Time `json:"started_at"`
+	At         time.Time `json:"at"`
+	Duration   float64   `json:"duration_seconds"` // since StartedAt
+}
+
+// LiveEvents reports game changes the moment the tracker sees them, plus a
+// heartbeat while a game runs, so the website can show who is playing right
+// now. Delivery is best effort: finished sessions still go to the sinks.
+type LiveEvents struct {
+	clock  Clock
+	send   func(url, token string, ev Event) error
+	events chan Event
+
+	mu       sync.Mutex
+	current  *Event    // start event of the game being played
+	since    time.Time // when it started, with its monotonic reading
+	lastSent time.Time
+	failing  bool
+}
+
+func newLiveEvents(clock Clock) *LiveEvents {
+	return &LiveEvents{clock: clock, send: postEvent, events: make(chan Event, 16)}
+}
+
+// GameChanged is called by the tracker, with nil when nothing is played.
+func (l *LiveEvents) GameChanged(g *DetectedGame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if l.current != nil {
+		l.queue(l.event("end", now))
+		l.current = nil
+	}
+	if g != nil {
+		l.current = &Event{
+			SessionID:  newSessionID(),
+			MachineID:  machineID(),
+			DeviceName: configuredDeviceName(),
+			Game:       g.game(),
+			PIDs:       g.PIDs,
+			StartedAt:  now.UTC(),
+		}
+		l.since = now
+		l.queue(l.event("start", now))
+	}
+}
+
+// heartbeat queues a heartbeat when a game is running and nothing was sent
+// for interval.
+func (l *LiveEvents) heartbeat(interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if l.current == nil || interval <= 0 || now.Sub(l.lastSent) < interval {
+		return
+	}
+	l.queue(l.event("heartbeat", now))
+}
+
+// Stop sends the end event for a running game directly, for use at exit.
+func (l *LiveEvents) Stop(url, token string) {
+	l.mu.Lock()
+	if l.current == nil {
+		l.mu.Unlock()
+		return
+	}
+	ev := l.event("end", l.clock.Now())
+	l.current = nil
+	l.mu.Unlock()
+	l.deliver(url, token, ev)
+}
+
+// event must be called with l.mu held and l.current set.
+func (l *LiveEvents) event(typ string, now time.Time) Event {
+	ev := *l.current
+	ev.Type, ev.At = typ, now.UTC()
+	ev.Duration = now.Sub(l.since).Seconds()
+	l.lastSent = now
+	return ev
+}
+
+func (l *LiveEvents) queue(ev Event) {
+	select {
+	case l.events <- ev:
+	default:
+		log.Printf("Live event queue full, dropping %s event", ev.Type)
+	}
+}
+
+func (l *LiveEvents) deliver(url, token string, ev Event) {
+	if url == "" {
+		return
+	}
+	err := l.send(url, token, ev)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil && !l.failing {
+		log.Printf("Live events failing: %v", err)
+	} else if err == nil && l.failing {
+		log.Printf("Live events delivered again")
+	}
+	l.failing = err != nil
+}
+
+// runLiveEvents delivers queued events in order and sends heartbeats.
+func runLiveEvents(ctx context.Context, l *LiveEvents) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-l.events:
+			c := cfg.Load()
+			l.deliver(c.EventsURL, c.EventsToken, ev)
+		case <-ticker.C:
+			l.heartbeat(time.Duration(cfg.Load().HeartbeatSeconds) * time.Second)
+		}
+	}
+}
+
+func postEvent(url, token string, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := reportClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLiveEvents(t *testing.T) {
+	clock := newFakeClock()
+	l := newLiveEvents(clock)
+	var sent []Event
+	l.send = func(_, _ string, ev Event) error {
+		sent = append(sent, ev)
+		return nil
+	}
+
+	l.GameChanged(&DetectedGame{Name: "Factorio", Source: "config", Process: "factorio"})
+	clock.Advance(30 * time.Second)
+	l.heartbeat(time.Minute)
+	clock.Advance(40 * time.Second)
+	l.heartbeat(time.Minute)
+	clock.Advance(10 * time.Second)
+	l.GameChanged(&DetectedGame{Name: "Counter-Strike 2", Source: "steam", SteamAppID: 730, Process: "cs2", PIDs: []int{42, 40}})
+	clock.Advance(time.Minute)
+	l.Stop("https://example.com/events", "secret")
+
+	close(l.events)
+	var got []Event
+	for ev := range l.events {
+		got = append(got, ev)
+	}
+	got = append(got, sent...)
+
+	want := []struct {
+		typ      string
+		game     string
+		duration time.Duration
+	}{
+		{"start", "Factorio", 0},
+		{"heartbeat", "Factorio", 70 * time.Second},
+		{"end", "Factorio", 80 * time.Second},
+		{"start", "Counter-Strike 2", 0},
+		{"end", "Counter-Strike 2", time.Minute},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		ev := got[i]
+		if ev.Type != w.typ || ev.Game.Name != w.game || ev.Duration != w.duration.Seconds() {
+			t.Errorf("event %d = %s %s %.0fs, want %s %s %.0fs", i, ev.Type, ev.Game.Name, ev.Duration, w.typ, w.game, w.duration.Seconds())
+		}
+	}
+	if pids := got[4].PIDs; len(pids) != 2 || pids[0] != 42 {
+		t.Errorf("end event pids %v, want [42 40]", pids)
+	}
+	if got[0].SessionID != got[2].SessionID || got[0].SessionID == got[3].SessionID {
+		t.Errorf("session IDs don't follow the games: %q %q %q", got[0].SessionID, got[2].SessionID, got[3].SessionID)
+	}
+}
+
+func TestPostEventToken(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	if err := postEvent(srv.URL, "secret", Event{Type: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if defaultConfig().EventsURL != "" {
+		t.Error("live events must be off by default")
+	}
+}
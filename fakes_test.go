@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{t: testEpoch} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Set moves the clock to testEpoch plus offset.
+func (c *fakeClock) Set(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = testEpoch.Add(offset)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// fakeProcesses is a ProcessProvider backed by plain fields.
+type fakeProcesses struct {
+	steamAppID   int
+	steamProcess string
+	steamPIDs    []int
+	running      []string
+	window       string
+	windowTitle  string
+	windowPID    int
+}
+
+func (p *fakeProcesses) SteamRunningApp() (SteamApp, error) {
+	if p.steamAppID == 0 {
+		return SteamApp{}, fmt.Errorf("no steam game running")
+	}
+	return SteamApp{AppID: p.steamAppID, Process: p.steamProcess, PIDs: p.steamPIDs}, nil
+}
+
+func (p *fakeProcesses) RunningProcesses() ([]string, error) {
+	return p.running, nil
+}
+
+func (p *fakeProcesses) ActiveWindow() (int, string, string, error) {
+	return p.windowPID, p.window, p.windowTitle, nil
+}
+
+// fakeSteam is a SteamCatalog that knows a fixed set of games.
+type fakeSteam map[int]string
+
+func (s fakeSteam) GameName(appID int) (string, error) {
+	name, ok := s[appID]
+	if !ok {
+		return "", fmt.Errorf("steam app %d not found", appID)
+	}
+	return name, nil
+}
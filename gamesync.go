@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sharedGames is the team-wide game list served next to the sessions API.
+type sharedGames struct {
+	Version int         `json:"version"`
+	Games   []GameEntry `json:"games"`
+}
+
+// gameSyncCache is the on-disk copy of the last list fetched.
+type gameSyncCache struct {
+	ETag string `json:"etag"`
+	sharedGames
+}
+
+// GameSync keeps a local copy of the shared game list, refreshed from the
+// server with conditional requests and cached so it works offline.
+type GameSync struct {
+	mu       sync.Mutex
+	url      string
+	path     string
+	etag     string
+	list     sharedGames
+	interval time.Duration
+	changed  chan struct{} // wakes runGameSync when the interval changes
+	// onUpdate is called with the new list after a refresh changed it.
+	onUpdate func([]GameEntry)
+}
+
+func newGameSync(url, path string, interval time.Duration) *GameSync {
+	s := &GameSync{url: url, path: path, interval: interval, changed: make(chan struct{}, 1)}
+	s.load()
+	return s
+}
+
+// Games returns the shared game list.
+func (s *GameSync) Games() []GameEntry {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list.Games
+}
+
+// SetURL points the sync at a different server; an empty URL disables it.
+func (s *GameSync) SetURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.url != url {
+		s.url, s.etag = url, ""
+	}
+}
+
+// SetInterval changes how often the list is refreshed.
+func (s *GameSync) SetInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interval != d {
+		s.interval = d
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+var gameSyncClient = newHTTPClient(10 * time.Second)
+
+// Refresh fetches the list if it changed since the last fetch.
+func (s *GameSync) Refresh() error {
+	s.mu.Lock()
+	url, etag := s.url, s.etag
+	s.mu.Unlock()
+	if url == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := gameSyncClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	var list sharedGames
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	valid := list.Games[:0]
+	for _, g := range list.Games {
+		if strings.TrimSpace(g.Process) != "" && strings.TrimSpace(g.Name) != "" {
+			valid = append(valid, g)
+		}
+	}
+	list.Games = valid
+
+	s.mu.Lock()
+	// A stale cache in front of the server must not roll the list back
+	if list.Version < s.list.Version {
+		s.mu.Unlock()
+		return fmt.Errorf("server sent version %d, have %d", list.Version, s.list.Version)
+	}
+	changed := list.Version != s.list.Version
+	s.list = list
+	s.etag = resp.Header.Get("ETag")
+	s.save()
+	s.mu.Unlock()
+	if changed {
+		log.Printf("Shared game list updated to version %d (%d games)", list.Version, len(list.Games))
+		if s.onUpdate != nil {
+			s.onUpdate(list.Games)
+		}
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *GameSync) save() {
+	data, err := json.MarshalIndent(gameSyncCache{ETag: s.etag, sharedGames: s.list}, "", "  ")
+	if err != nil {
+		log.Printf("game list save: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		log.Printf("game list write: %v", err)
+	}
+}
+
+func (s *GameSync) load() {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("game list load: %v", err)
+		return
+	}
+	var c gameSyncCache
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Printf("game list parse: %v", err)
+		return
+	}
+	s.etag, s.list = c.ETag, c.sharedGames
+}
+
+func runGameSync(ctx context.Context, s *GameSync) {
+	var last time.Time
+	refresh := func() {
+		last = time.Now()
+		if err := s.Refresh(); err != nil {
+			log.Printf("Game list sync failed: %v", err)
+		}
+	}
+	refresh()
+
+	for {
+		s.mu.Lock()
+		interval := max(s.interval, time.Minute)
+		s.mu.Unlock()
+		timer := time.NewTimer(time.Until(last.Add(interval)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C:
+			refresh()
+		}
+	}
+}
+
+// mergeGames combines the local and shared game lists. Local entries come
+// first and override shared entries for the same process.
+func mergeGames(local, shared []GameEntry) []GameEntry {
+	if len(shared) == 0 {
+		return local
+	}
+	seen := make(map[string]bool, len(local))
+	for _, g := range local {
+		if g.Process != "" {
+			seen[strings.ToLower(baseNameNoExt(g.Process))] = true
+		}
+	}
+	merged := append([]GameEntry(nil), local...)
+	for _, g := range shared {
+		if !seen[strings.ToLower(baseNameNoExt(g.Process))] {
+			merged = append(merged, g)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGameSyncConditionalRefresh(t *testing.T) {
+	var mu sync.Mutex
+	version, requests, notModified := 1, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		etag := fmt.Sprintf(`"v%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"version": %d, "games": [
+			{"process": "factorio", "name": "Factorio"},
+			{"process": "", "name": "Broken"},
+			{"process": "RimWorldWin64.exe", "name": "RimWorld v%d"}
+		]}`, version, version)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "games.json")
+	s := newGameSync(srv.URL, path, time.Hour)
+	if err := s.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := s.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if notModified != 1 {
+		t.Errorf("got %d not-modified replies, want 1", notModified)
+	}
+	if got := len(s.Games()); got != 2 {
+		t.Fatalf("got %d games, want 2 (invalid entry dropped)", got)
+	}
+
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	if err := s.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := s.Games()[1].Name; got != "RimWorld v2" {
+		t.Errorf("got %q after update, want RimWorld v2", got)
+	}
+
+	// A restart picks up the cached list and its ETag
+	cached := newGameSync(srv.URL, path, time.Hour)
+	if got := len(cached.Games()); got != 2 {
+		t.Fatalf("cache holds %d games, want 2", got)
+	}
+	if err := cached.Refresh(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if notModified != 2 {
+		t.Errorf("cached ETag not sent: %d not-modified replies, want 2", notModified)
+	}
+}
+
+func TestGameSyncRejectsOlderVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"version": 3, "games": [{"process": "old", "name": "Old"}]}`)
+	}))
+	defer srv.Close()
+
+	s := newGameSync(srv.URL, filepath.Join(t.TempDir(), "games.json"), time.Hour)
+	s.list = sharedGames{Version: 5, Games: []GameEntry{{Process: "new", Name: "New"}}}
+	if err := s.Refresh(); err == nil {
+		t.Fatal("expected older version to be rejected")
+	}
+	if got := s.Games()[0].Name; got != "New" {
+		t.Errorf("list rolled back to %q", got)
+	}
+}
+
+func TestMergeGamesLocalOverrides(t *testing.T) {
+	local := []GameEntry{
+		{Process: "RimWorldWin64", Name: "My RimWorld"},
+		{Name: "Counter-Strike 2"},
+	}
+	shared := []GameEntry{
+		{Process: "factorio", Name: "Factorio"},
+		{Process: "RimWorldWin64.exe", Name: "RimWorld"},
+	}
+
+	games := mergeGames(local, shared)
+	if len(games) != 3 {
+		t.Fatalf("got %d games, want 3: %+v", len(games), games)
+	}
+	if g := matchConfigGame(games, "RimWorldWin64"); g == nil || g.Name != "My RimWorld" {
+		t.Errorf("local entry did not override shared one: %+v", g)
+	}
+	if g := matchConfigGame(games, "factorio"); g == nil || g.Name != "Factorio" {
+		t.Errorf("shared entry not matched: %+v", g)
+	}
+}
+
+func TestGameSyncSessionPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"version": 1, "games": [
+			{"process": "factorio", "name": "Factorio", "min_session_seconds": 120},
+			{"process": "RimWorldWin64", "name": "RimWorld", "min_session_seconds": 300}
+		]}`)
+	}))
+	defer srv.Close()
+
+	local := 60
+	c := &Config{MinSessionSeconds: 10, Games: []GameEntry{{Name: "RimWorld", MinSessionSeconds: &local}}}
+	var policy SessionPolicy
+	s := newGameSync(srv.URL, filepath.Join(t.TempDir(), "games.json"), time.Hour)
+	s.onUpdate = func(games []GameEntry) { policy = c.sessionPolicy(games) }
+	if err := s.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := policy.minDuration(Game{Name: "Factorio"}); got != 2*time.Minute {
+		t.Errorf("Factorio minimum %s, want the shared list's 2m", got)
+	}
+	if got := policy.minDuration(Game{Name: "RimWorld"}); got != time.Minute {
+		t.Errorf("RimWorld minimum %s, want the local 1m", got)
+	}
+}
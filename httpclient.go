@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// NetworkConfig applies to every connection the agent makes.
+type NetworkConfig struct {
+	// Proxy is an http://, https:// or socks5:// URL. Empty uses the
+	// HTTPS_PROXY/NO_PROXY environment, "direct" ignores it.
+	Proxy string `json:"proxy,omitempty"`
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// ClientCert and ClientKey are PEM files presented for mutual TLS.
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// transport is shared by every HTTP request, so proxy and TLS settings apply
+// everywhere. configureNetwork swaps it when the config changes.
+var (
+	transport atomic.Pointer[http.Transport]
+	tlsConfig atomic.Pointer[tls.Config]
+)
+
+func init() {
+	transport.Store(http.DefaultTransport.(*http.Transport).Clone())
+	tlsConfig.Store(&tls.Config{})
+}
+
+// sharedTransport sends requests over the current transport.
+type sharedTransport struct{}
+
+func (sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return transport.Load().RoundTrip(req)
+}
+
+// newHTTPClient returns a client that follows the network config.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: sharedTransport{}}
+}
+
+// configureNetwork rebuilds the shared transport from nc.
+func configureNetwork(nc NetworkConfig) error {
+	tc, err := buildTLSConfig(nc)
+	if err != nil {
+		return err
+	}
+	proxy, err := proxyFunc(nc.Proxy)
+	if err != nil {
+		return err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.TLSClientConfig = tc
+
+	old := transport.Swap(t)
+	tlsConfig.Store(tc)
+	old.CloseIdleConnections()
+	return nil
+}
+
+// networkTLSConfig returns the TLS settings for non-HTTP connections.
+func networkTLSConfig() *tls.Config {
+	return tlsConfig.Load().Clone()
+}
+
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, &ConfigError{Field: "network.proxy", Msg: err.Error()}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, &ConfigError{Field: "network.proxy", Msg: fmt.Sprintf("%q is not an http(s):// or socks5:// URL", proxy)}
+	}
+	return http.ProxyURL(u), nil
+}
+
+func buildTLSConfig(nc NetworkConfig) (*tls.Config, error) {
+	tc := &tls.Config{}
+	if nc.CAFile != "" {
+		pem, err := os.ReadFile(nc.CAFile)
+		if err != nil {
+			return nil, &ConfigError{Field: "network.ca_file", Msg: err.Error()}
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, &ConfigError{Field: "network.ca_file", Msg: "no PEM certificates found"}
+		}
+		tc.RootCAs = pool
+	}
+
+	switch {
+	case nc.ClientCert != "" && nc.ClientKey == "":
+		return nil, &ConfigError{Field: "network.client_key", Msg: "required with client_cert"}
+	case nc.ClientCert == "" && nc.ClientKey != "":
+		return nil, &ConfigError{Field: "network.client_cert", Msg: "required with client_key"}
+	case nc.ClientCert != "":
+		cert, err := tls.LoadX509KeyPair(nc.ClientCert, nc.ClientKey)
+		if err != nil {
+			return nil, &ConfigError{Field: "network.client_cert", Msg: err.Error()}
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
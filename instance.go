@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errAlreadyRunning is returned by lockInstance while another agent runs.
+var errAlreadyRunning = errors.New("agent is already running")
+
+// lockInstance makes this the only running agent for the user, so two
+// trackers never share buffer.json. The lock file holds the PID; the lock
+// is released when the process exits, however it exits.
+func lockInstance() (*os.File, error) {
+	if err := os.MkdirAll(dataDir(), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dataDir(), "agent.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errAlreadyRunning) {
+			if pid := runningPID(); pid != 0 {
+				return nil, fmt.Errorf("%w (pid %d)", err, pid)
+			}
+		}
+		return nil, err
+	}
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return f, nil
+}
+
+// runningPID returns the PID in the lock file, or 0.
+func runningPID() int {
+	data, err := os.ReadFile(filepath.Join(dataDir(), "agent.lock"))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+func controlSocketPath() string {
+	return filepath.Join(dataDir(), "agent.sock")
+}
+
+// listenControl opens the socket other launches of the agent send commands
+// to. Only call it while holding the instance lock: a socket file left
+// behind by a crash is removed.
+func listenControl(path string) (net.Listener, error) {
+	_ = os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// serveControl answers commands on ln, one line per connection, with the
+// result of handle. Errors are answered as "error: <message>".
+func serveControl(ctx context.Context, ln net.Listener, handle func(cmd string) (string, error)) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Control socket: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(time.Minute))
+			cmd, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			reply, err := handle(strings.TrimSpace(cmd))
+			if err != nil {
+				reply = "error: " + err.Error()
+			}
+			fmt.Fprintln(conn, reply)
+		}()
+	}
+}
+
+// sendControl sends cmd to the running agent and returns its reply.
+func sendControl(path, cmd string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return "", errors.New("agent is not running")
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	if _, err := bufio.NewReader(conn).WriteTo(&reply); err != nil {
+		return "", err
+	}
+	out := strings.TrimSuffix(reply.String(), "\n")
+	if msg, ok := strings.CutPrefix(out, "error: "); ok {
+		return "", errors.New(msg)
+	}
+	return out, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockInstance(t *testing.T) {
+	f, err := lockInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockInstance(); !errors.Is(err, errAlreadyRunning) {
+		t.Fatalf("second lock: got %v, want errAlreadyRunning", err)
+	}
+	if pid := runningPID(); pid != os.Getpid() {
+		t.Errorf("lock file holds pid %d, want %d", pid, os.Getpid())
+	}
+	f.Close()
+	f, err = lockInstance()
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	f.Close()
+}
+
+func TestControlSocket(t *testing.T) {
+	// Short path: unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "agent.sock")
+
+	if _, err := sendControl(path, "status"); err == nil {
+		t.Fatal("expected an error without a running agent")
+	}
+
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveControl(ctx, ln, func(cmd string) (string, error) {
+		if cmd == "status" {
+			return "playing: Factorio\nprofile: Anna", nil
+		}
+		return "", fmt.Errorf("unknown command %q", cmd)
+	})
+
+	reply, err := sendControl(path, "status")
+	if err != nil || reply != "playing: Factorio\nprofile: Anna" {
+		t.Errorf("status: got %q, %v", reply, err)
+	}
+	if _, err := sendControl(path, "dance"); err == nil || err.Error() != `unknown command "dance"` {
+		t.Errorf("unknown command: got %v", err)
+	}
+}
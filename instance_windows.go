@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = kernel32.NewProc("LockFileEx")
+
+const (
+	lockfileExclusiveLock   = 0x2
+	lockfileFailImmediately = 0x1
+	errorLockViolation      = syscall.Errno(33)
+	// lockOffset puts the locked byte past the PID: Windows locks are
+	// mandatory, and a lock on the PID would keep other launches from
+	// reading it.
+	lockOffset = 1 << 20
+)
+
+func lockFile(f *os.File) error {
+	overlapped := syscall.Overlapped{Offset: lockOffset}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errAlreadyRunning
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLockInstanceReportsPID(t *testing.T) {
+	f, err := lockInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The lock must leave the PID readable to the second launch
+	_, err = lockInstance()
+	if !errors.Is(err, errAlreadyRunning) {
+		t.Fatalf("second lock: got %v, want errAlreadyRunning", err)
+	}
+	if want := fmt.Sprintf("(pid %d)", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not name %s", err, want)
+	}
+}
@@ -3,8 +3,14 @@ package main
 import (
 	_ "embed"
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/getlantern/systray"
@@ -17,24 +23,57 @@ var iconLinux []byte
 var iconWindows []byte
 
 var (
-	cfg    *Config
+	cfg    atomic.Pointer[Config] // swapped as a whole when config.json changes
 	buf    *SessionBuffer
+	outbox *Outbox
+	events *LiveEvents
 	cancel context.CancelFunc
+	// netState is the last network state the OS reported, nil until then
+	netState atomic.Pointer[NetworkState]
+	// instanceLock is held open for the life of the process
+	instanceLock *os.File
 )
 
 func main() {
+	if handled, code := runCommand(os.Args[1:], os.Stdout, os.Stderr); handled {
+		os.Exit(code)
+	}
+	var err error
+	if instanceLock, err = lockInstance(); errors.Is(err, errAlreadyRunning) {
+		log.Printf("Not starting: %v", err)
+		os.Exit(0)
+	} else if err != nil {
+		log.Printf("Instance lock: %v", err)
+	}
 	systray.Run(onReady, onExit)
 }
 
 func onReady() {
-	var err error
-	cfg, err = loadConfig()
-	if err != nil {
-		log.Printf("Config load failed: %v, using defaults", err)
-		cfg = defaultConfig()
+	// Before loadConfig creates config.json, see device
+	log.Printf("Device ID %s", machineID())
+	c, configErr := loadConfig()
+	if configErr != nil {
+		if last, err := loadLastGoodConfig(); err == nil {
+			log.Printf("Config load failed: %v, using last working config", configErr)
+			c = last
+		} else {
+			log.Printf("Config load failed: %v, using defaults", configErr)
+			c = defaultConfig()
+		}
+	}
+	cfg.Store(c)
+	if err := configureNetwork(c.Network); err != nil {
+		log.Printf("Network config: %v", err)
 	}
 
-	buf = newSessionBuffer()
+	clock := systemClock{}
+	shared := newGameSync(c.GamesURL, filepath.Join(dataDir(), "games.json"), c.gamesSyncInterval())
+	buf = newSessionBuffer(c.sessionPolicy(shared.Games()), clock)
+	shared.onUpdate = func(games []GameEntry) {
+		buf.SetPolicy(cfg.Load().sessionPolicy(games))
+	}
+	outbox = newOutbox(filepath.Join(dataDir(), "outbox"), clock)
+	reportOrphans(c)
 
 	if runtime.GOOS == "windows" {
 		systray.SetIcon(iconWindows)
@@ -43,106 +82,237 @@ func onReady() {
 	}
 	systray.SetTooltip("Dazuukiknie Agent")
 
-	mStatus := systray.AddMenuItem("Not playing", "Currently detected game")
-	mStatus.Disable()
-	systray.AddSeparator()
-	mPush := systray.AddMenuItem("Push update", "Send pending sessions now")
-	systray.AddSeparator()
-	mQuit := systray.AddMenuItem("Quit", "Stop tracking")
+	menu := newTrayMenu()
+	if configErr != nil {
+		menu.showConfigError(configErr)
+	}
+	menu.setProfiles(c)
+	buf.SetProfile(c.Profile)
 
 	ctx, cancelFn := context.WithCancel(context.Background())
 	cancel = cancelFn
 
-	go runDetection(ctx, mStatus)
-	go runReporter(ctx)
-
-	go func() {
-		for {
-			select {
-			case <-mPush.ClickedCh:
-				go forcePush()
-			case <-mQuit.ClickedCh:
-				systray.Quit()
-			case <-ctx.Done():
-				return
-			}
+	events = newLiveEvents(clock)
+	mqttPub := newMQTTPublisher(clock)
+	discord := newDiscordPresence(clock)
+	tracker := newTracker(newDetector(clock, shared), buf, func(g *DetectedGame) {
+		public := cfg.Load().Privacy.detected(g)
+		events.GameChanged(public)
+		mqttPub.GameChanged(public)
+		discord.GameChanged(public)
+		menu.gameChanged(g)
+	})
+	menu.tracker = tracker
+
+	go runDetection(ctx, tracker)
+	network := make(chan NetworkState, 1)
+	go runNetworkMonitor(ctx, func(s NetworkState) {
+		menu.setNetwork(s)
+		netState.Store(&s)
+		select {
+		case network <- s:
+		case <-ctx.Done():
+		}
+	})
+	go runReporter(ctx, network)
+	go runLiveEvents(ctx, events)
+	go runMQTT(ctx, mqttPub)
+	go runDiscord(ctx, discord)
+	go runGameSync(ctx, shared)
+	go runConfigWatcher(ctx, func(next *Config, err error) {
+		if err != nil {
+			log.Printf("Config reload rejected, keeping current config: %v", err)
+			menu.showConfigError(err)
+			return
+		}
+		menu.hideConfigError()
+		cfg.Store(next)
+		if err := configureNetwork(next.Network); err != nil {
+			log.Printf("Network config: %v", err)
 		}
-	}()
+		saveLastGoodConfig(next)
+		buf.SetPolicy(next.sessionPolicy(shared.Games()))
+		buf.SetProfile(next.Profile)
+		menu.setProfiles(next)
+		shared.SetURL(next.GamesURL)
+		shared.SetInterval(next.gamesSyncInterval())
+		reportOrphans(next)
+		log.Printf("Config reloaded")
+	})
+	go menu.run(ctx)
+
+	// Without the lock another agent may own the socket, so leave it alone
+	if instanceLock == nil {
+		log.Printf("Control socket: off without the instance lock")
+	} else if ln, err := listenControl(controlSocketPath()); err != nil {
+		log.Printf("Control socket: %v", err)
+	} else {
+		go serveControl(ctx, ln, func(cmd string) (string, error) {
+			switch cmd {
+			case "push":
+				c := cfg.Load()
+				if len(c.Sinks) == 0 {
+					return "", errors.New("no sinks configured, sessions stay buffered")
+				}
+				deliveries, err := flushSessions(context.Background(), buf, outbox, c, true)
+				lines := make([]string, len(deliveries))
+				for i, d := range deliveries {
+					lines[i] = d.String()
+				}
+				if err != nil {
+					return "", errors.New(strings.Join(lines, "\n"))
+				}
+				return strings.Join(lines, "\n"), nil
+			case "status":
+				return menu.statusText(), nil
+			}
+			return "", fmt.Errorf("unknown command %q", cmd)
+		})
+	}
 }
 
 func onExit() {
 	if cancel != nil {
 		cancel()
 	}
-	buf.EndGame()
-	sessions := buf.Drain()
-	if err := sendReport(sessions, cfg); err != nil {
+	c := cfg.Load()
+	events.Stop(c.EventsURL, c.EventsToken)
+	buf.Flush()
+	if _, err := flushSessions(context.Background(), buf, outbox, c, true); err != nil {
 		log.Printf("Final flush failed: %v", err)
-		buf.Restore(sessions)
 	}
 }
 
-func runDetection(ctx context.Context, mStatus *systray.MenuItem) {
+func runDetection(ctx context.Context, tracker *Tracker) {
 	ticker := time.NewTicker(3 * time.Second)
 	defer ticker.Stop()
+	focus := windowFocusEvents()
+	procs := processEvents()
+	power := powerEvents(ctx)
 
-	var current *DetectedGame
+	tracker.SyncClock(time.Now(), uptime())
+	tick := func() {
+		tracker.CheckClock(time.Now(), uptime())
+		tracker.Tick(cfg.Load())
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			detected := Detect(cfg)
-
-			if detected == nil {
-				if current != nil {
-					log.Printf("Game ended: %s", current.Name)
-					buf.EndGame()
-					current = nil
-					mStatus.SetTitle("Not playing")
-				}
-				continue
-			}
-
-			if current == nil || current.Name != detected.Name {
-				if current != nil {
-					buf.EndGame()
-				}
-				current = detected
-				buf.StartGame(Game{
-					Name:       detected.Name,
-					Source:     detected.Source,
-					SteamAppID: detected.SteamAppID,
-					Process:    detected.Process,
-				})
-				mStatus.SetTitle("Playing: " + detected.Name)
-				log.Printf("Game started: %s (%s)", detected.Name, detected.Source)
+			tick()
+		case <-focus:
+			tick()
+		case <-procs:
+			tick()
+		case e := <-power:
+			tracker.Suspend(time.Now())
+			if e.shutdown {
+				reportBeforeShutdown(ctx)
 			}
+			e.done()
+			tracker.SyncClock(time.Now(), uptime())
 		}
 	}
 }
 
-func runReporter(ctx context.Context) {
+// shutdownReportTimeout keeps the report before shutdown within logind's
+// default InhibitDelayMaxSec of 5 seconds.
+const shutdownReportTimeout = 3 * time.Second
+
+// reportBeforeShutdown saves the sessions and sends them if the network
+// allows it; whatever isn't sent in time goes out after the next start.
+func reportBeforeShutdown(ctx context.Context) {
+	buf.Flush()
+	c := cfg.Load()
+	if s := currentNetwork(); !s.allowsReporting(c) {
+		log.Printf("Network is %s, reporting after the next start", s)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, shutdownReportTimeout)
+	defer cancel()
+	if _, err := flushSessions(ctx, buf, outbox, c, true); err != nil {
+		log.Printf("Report before shutdown failed: %v", err)
+	}
+}
+
+// currentNetwork returns the last network state the OS reported.
+func currentNetwork() NetworkState {
+	if s := netState.Load(); s != nil {
+		return *s
+	}
+	return NetworkState{}
+}
+
+// reportOrphans logs outbox queues left behind by sinks that were renamed or
+// removed from the config.
+func reportOrphans(c *Config) {
+	for name, n := range outbox.Orphans(c.Sinks) {
+		log.Printf("Outbox holds %d unsent session(s) for sink %q, which is no longer configured; add it back or rename %s to send them",
+			n, name, outbox.path(name))
+	}
+}
+
+// runReporter delivers sessions every few minutes while the network allows
+// it, right away when a held-back connection comes back, and when a failed
+// sink's backoff runs out.
+func runReporter(ctx context.Context, network <-chan NetworkState) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
-
+	var state NetworkState
+	report := func() {
+		c := cfg.Load()
+		if !state.allowsReporting(c) {
+			return
+		}
+		if buf.HasPending() || outbox.HasPending(c.Sinks) {
+			if _, err := flushSessions(ctx, buf, outbox, c, false); err != nil {
+				log.Printf("Report failed: %v", err)
+			}
+		}
+	}
 	for {
+		var retry <-chan time.Time
+		if d, ok := outbox.NextRetry(cfg.Load().Sinks); ok {
+			retry = time.After(d)
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if buf.HasPending() {
+		case s := <-network:
+			held := !state.allowsReporting(cfg.Load())
+			state = s
+			if held && state.allowsReporting(cfg.Load()) {
 				forcePush()
 			}
+		case <-ticker.C:
+			report()
+		case <-retry:
+			report()
+		case <-outbox.Retries():
 		}
 	}
 }
 
 func forcePush() {
-	sessions := buf.Drain()
-	if err := sendReport(sessions, cfg); err != nil {
+	if _, err := flushSessions(context.Background(), buf, outbox, cfg.Load(), true); err != nil {
 		log.Printf("Report failed: %v", err)
-		buf.Restore(sessions)
 	}
 }
+
+// flushSessions hands finished sessions to the outbox, minus what the privacy
+// settings hold back, and delivers it, returning what each sink got. Without
+// sinks the sessions stay buffered until one is configured.
+func flushSessions(ctx context.Context, b *SessionBuffer, o *Outbox, c *Config, force bool) ([]Delivery, error) {
+	if len(c.Sinks) == 0 {
+		return nil, nil
+	}
+	o.Add(c.Sinks, c.Privacy.sessions(b.Drain()))
+	return o.Deliver(ctx, c.Sinks, force)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain keeps files the agent creates on first use, like device.json, out
+// of the real home directory.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "dazuukiknie-test")
+	if err != nil {
+		panic(err)
+	}
+	for _, env := range []string{"HOME", "APPDATA", "LOCALAPPDATA"} {
+		os.Setenv(env, home)
+	}
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
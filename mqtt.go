@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Just enough of MQTT 3.1.1 to publish retained QoS 0 messages.
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPingreq    = 0xc0
+	mqttPingresp   = 0xd0
+	mqttDisconnect = 0xe0
+
+	mqttKeepAlive = 60 * time.Second
+)
+
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// mqttClient is a publish-only MQTT connection.
+type mqttClient struct {
+	conn net.Conn
+	mu   sync.Mutex // serialises writes
+	dead chan struct{}
+	err  error
+}
+
+// dialMQTT connects to broker (tcp://, mqtt://, tls:// or mqtts://) and
+// registers will to be published by the broker if the connection drops.
+func dialMQTT(broker, clientID, username, password string, will mqttMessage) (*mqttClient, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		tc := networkTLSConfig()
+		tc.ServerName = u.Hostname()
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), tc)
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttClient{conn: conn, dead: make(chan struct{})}
+	if err := c.connect(clientID, username, password, will); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.read()
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func (c *mqttClient) connect(clientID, username, password string, will mqttMessage) error {
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, will, will retain
+	var payload []byte
+	payload = appendMQTTString(payload, clientID)
+	payload = appendMQTTString(payload, will.Topic)
+	payload = appendMQTTBytes(payload, will.Payload)
+	if username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, username)
+		// MQTT 3.1.1 only allows a password after a username
+		if password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, password)
+		}
+	}
+
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+	if err := c.write(mqttConnect, body); err != nil {
+		return err
+	}
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	typ, ack, err := readMQTTPacket(bufio.NewReader(c.conn))
+	if err != nil {
+		return fmt.Errorf("connack: %w", err)
+	}
+	if typ&0xf0 != mqttConnack || len(ack) != 2 {
+		return fmt.Errorf("unexpected packet 0x%02x instead of connack", typ)
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused connection (code %d)", ack[1])
+	}
+	return nil
+}
+
+// read drains packets from the broker (only PINGRESP is expected) until the
+// connection fails.
+func (c *mqttClient) read() {
+	r := bufio.NewReader(c.conn)
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		if _, _, err := readMQTTPacket(r); err != nil {
+			c.err = err
+			close(c.dead)
+			return
+		}
+	}
+}
+
+// Publish sends a retained QoS 0 message.
+func (c *mqttClient) Publish(m mqttMessage) error {
+	body := appendMQTTString(nil, m.Topic)
+	body = append(body, m.Payload...)
+	return c.write(mqttPublish|0x01, body)
+}
+
+func (c *mqttClient) Ping() error {
+	return c.write(mqttPingreq, nil)
+}
+
+// Close disconnects cleanly, so the broker doesn't publish the will.
+func (c *mqttClient) Close() error {
+	_ = c.write(mqttDisconnect, nil)
+	return c.conn.Close()
+}
+
+// Dead is closed when the connection to the broker is lost.
+func (c *mqttClient) Dead() <-chan struct{} {
+	return c.dead
+}
+
+func (c *mqttClient) write(typ byte, body []byte) error {
+	pkt := []byte{typ}
+	pkt = appendMQTTLength(pkt, len(body))
+	pkt = append(pkt, body...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+func appendMQTTLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return appendMQTTBytes(b, []byte(s))
+}
+
+func appendMQTTBytes(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
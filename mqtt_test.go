@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is a minimal MQTT broker that keeps the retained messages.
+type testBroker struct {
+	ln       net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]bool // nil once the broker is closed
+	clientID string
+	will     string
+	flags    byte
+	retained map[string]string
+	updates  chan string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{ln: ln, conns: make(map[net.Conn]bool), retained: make(map[string]string), updates: make(chan string, 64)}
+	// Join every connection before the test ends, so none reports to a finished test
+	t.Cleanup(func() {
+		ln.Close()
+		b.mu.Lock()
+		for conn := range b.conns {
+			conn.Close()
+		}
+		b.conns = nil
+		b.mu.Unlock()
+		b.wg.Wait()
+	})
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			if b.conns == nil {
+				b.mu.Unlock()
+				conn.Close()
+				return
+			}
+			b.conns[conn] = true
+			b.mu.Unlock()
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				b.serve(t, conn)
+				b.mu.Lock()
+				delete(b.conns, conn)
+				b.mu.Unlock()
+			}()
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) url() string { return "tcp://" + b.ln.Addr().String() }
+
+func (b *testBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ & 0xf0 {
+		case mqttConnect:
+			// protocol name, level, flags, keep alive
+			str := func(p []byte) (string, []byte) {
+				n := binary.BigEndian.Uint16(p)
+				return string(p[2 : 2+n]), p[2+n:]
+			}
+			proto, rest := str(body)
+			if proto != "MQTT" || rest[0] != 4 {
+				t.Errorf("bad connect %q level %d", proto, rest[0])
+			}
+			var will string
+			b.mu.Lock()
+			b.flags = rest[1]
+			b.clientID, rest = str(rest[4:])
+			will, _ = str(rest)
+			b.will = will
+			b.mu.Unlock()
+			conn.Write([]byte{mqttConnack, 2, 0, 0})
+		case mqttPublish:
+			n := binary.BigEndian.Uint16(body)
+			topic, payload := string(body[2:2+n]), string(body[2+n:])
+			if typ&0x01 == 0 {
+				t.Errorf("publish to %s not retained", topic)
+			}
+			b.mu.Lock()
+			b.retained[topic] = payload
+			b.mu.Unlock()
+			select {
+			case b.updates <- topic:
+			default:
+			}
+		case mqttPingreq:
+			conn.Write([]byte{mqttPingresp, 0})
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+// waitFor waits until topic is published with a payload accepted by ok.
+func (b *testBroker) waitFor(t *testing.T, topic string, ok func(string) bool) string {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		b.mu.Lock()
+		payload, found := b.retained[topic]
+		b.mu.Unlock()
+		if found && ok(payload) {
+			return payload
+		}
+		select {
+		case <-b.updates:
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s, last payload %q", topic, payload)
+		}
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	broker := newTestBroker(t)
+	c := defaultConfig()
+	c.MQTT = MQTTConfig{Broker: broker.url(), Topic: "test/agent", Discovery: true, DiscoveryPrefix: "homeassistant"}
+	prev := cfg.Load()
+	t.Cleanup(func() { cfg.Store(prev) })
+	cfg.Store(c)
+
+	clock := newFakeClock()
+	p := newMQTTPublisher(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runMQTT(ctx, p)
+
+	broker.waitFor(t, "test/agent/availability", func(s string) bool { return s == "online" })
+	broker.waitFor(t, "test/agent/state", func(s string) bool { return s == `{"playing":false}` })
+	disc := broker.waitFor(t, "homeassistant/sensor/dazuukiknie_"+machineID()+"/game/config", func(string) bool { return true })
+	var entity map[string]any
+	if err := json.Unmarshal([]byte(disc), &entity); err != nil || entity["state_topic"] != "test/agent/state" {
+		t.Errorf("bad discovery config %s", disc)
+	}
+	broker.mu.Lock()
+	if broker.will != "test/agent/availability" {
+		t.Errorf("will topic %q", broker.will)
+	}
+	broker.mu.Unlock()
+
+	p.GameChanged(&DetectedGame{Name: "Factorio", Source: "config"})
+	clock.Advance(30 * time.Minute)
+	p.GameChanged(&DetectedGame{Name: "RimWorld", Source: "config"})
+	clock.Advance(15 * time.Minute)
+	p.GameChanged(nil)
+
+	broker.waitFor(t, "test/agent/state", func(s string) bool { return s == `{"playing":false}` })
+	var today mqttToday
+	broker.waitFor(t, "test/agent/today", func(s string) bool {
+		return json.Unmarshal([]byte(s), &today) == nil && today.TotalSeconds == 45*60
+	})
+	if today.Games["Factorio"] != 30*60 || today.Games["RimWorld"] != 15*60 {
+		t.Errorf("unexpected totals %+v", today)
+	}
+
+	cancel()
+	broker.waitFor(t, "test/agent/availability", func(s string) bool { return s == "offline" })
+}
+
+func TestMQTTPasswordNeedsUsername(t *testing.T) {
+	broker := newTestBroker(t)
+	for _, tt := range []struct {
+		username, password string
+		flags              byte // username and password flags
+	}{
+		{"", "secret", 0},
+		{"agent", "", 0x80},
+		{"agent", "secret", 0xc0},
+	} {
+		c, err := dialMQTT(broker.url(), "test", tt.username, tt.password, mqttMessage{Topic: "test/availability"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.conn.Close()
+		broker.mu.Lock()
+		if got := broker.flags & 0xc0; got != tt.flags {
+			t.Errorf("user %q, password %q: flags 0x%02x, want 0x%02x", tt.username, tt.password, got, tt.flags)
+		}
+		broker.mu.Unlock()
+	}
+}
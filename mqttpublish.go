@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// MQTTPublisher mirrors what the tray shows to retained MQTT topics, with
+// Home Assistant discovery so the agent shows up as a device there.
+type MQTTPublisher struct {
+	clock   Clock
+	changed chan struct{}
+
+	mu      sync.Mutex
+	current *DetectedGame
+	since   time.Time          // start of the current game, or of today if later
+	day     string             // local date the totals are for
+	today   map[string]float64 // seconds per game of finished plays today
+}
+
+type mqttState struct {
+	Playing    bool       `json:"playing"`
+	Game       string     `json:"game,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	SteamAppID int        `json:"steam_app_id,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+}
+
+type mqttToday struct {
+	Date         string             `json:"date"`
+	TotalSeconds float64            `json:"total_seconds"`
+	Games        map[string]float64 `json:"games"`
+}
+
+func newMQTTPublisher(clock Clock) *MQTTPublisher {
+	return &MQTTPublisher{clock: clock, changed: make(chan struct{}, 1), today: make(map[string]float64)}
+}
+
+// GameChanged is called by the tracker, with nil when nothing is played.
+func (p *MQTTPublisher) GameChanged(g *DetectedGame) {
+	p.mu.Lock()
+	now := p.clock.Now()
+	p.rollover(now)
+	if p.current != nil {
+		p.today[p.current.Name] += now.Sub(p.since).Seconds()
+	}
+	p.current, p.since = g, now
+	p.mu.Unlock()
+
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// rollover starts a new day's totals after midnight. Must be called with p.mu held.
+func (p *MQTTPublisher) rollover(now time.Time) {
+	day := now.Local().Format(time.DateOnly)
+	if day == p.day {
+		return
+	}
+	p.day = day
+	clear(p.today)
+	y, m, d := now.Local().Date()
+	if midnight := time.Date(y, m, d, 0, 0, 0, 0, time.Local); p.since.Before(midnight) {
+		p.since = midnight
+	}
+}
+
+// messages returns the state and daily totals topics under prefix.
+func (p *MQTTPublisher) messages(prefix string) []mqttMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock.Now()
+	p.rollover(now)
+
+	state := mqttState{}
+	today := mqttToday{Date: p.day, Games: maps.Clone(p.today)}
+	if g := p.current; g != nil {
+		state = mqttState{Playing: true, Game: g.Name, Source: g.Source, SteamAppID: g.SteamAppID}
+		started := p.since.UTC()
+		state.StartedAt = &started
+		today.Games[g.Name] += now.Sub(p.since).Seconds()
+	}
+	for _, s := range today.Games {
+		today.TotalSeconds += s
+	}
+
+	stateJSON, _ := json.Marshal(state)
+	todayJSON, _ := json.Marshal(today)
+	return []mqttMessage{
+		{Topic: prefix + "/state", Payload: stateJSON},
+		{Topic: prefix + "/today", Payload: todayJSON},
+	}
+}
+
+// discoveryMessages announce the agent's entities to Home Assistant.
+func discoveryMessages(prefix, discoveryPrefix, name string) []mqttMessage {
+	id := "dazuukiknie_" + machineID()
+	device := map[string]any{
+		"identifiers":  []string{id},
+		"name":         "Dazuukiknie " + name,
+		"manufacturer": "Dazuukiknie",
+		"model":        "Agent",
+	}
+	entity := func(component, object string, config map[string]any) mqttMessage {
+		config["unique_id"] = id + "_" + object
+		config["object_id"] = id + "_" + object
+		config["availability_topic"] = prefix + "/availability"
+		config["device"] = device
+		data, _ := json.Marshal(config)
+		return mqttMessage{Topic: discoveryPrefix + "/" + component + "/" + id + "/" + object + "/config", Payload: data}
+	}
+	return []mqttMessage{
+		entity("sensor", "game", map[string]any{
+			"name":                  "Game",
+			"icon":                  "mdi:gamepad-variant",
+			"state_topic":           prefix + "/state",
+			"value_template":        "{{ value_json.game if value_json.playing else 'Not playing' }}",
+			"json_attributes_topic": prefix + "/state",
+		}),
+		entity("binary_sensor", "playing", map[string]any{
+			"name":           "Playing",
+			"icon":           "mdi:controller",
+			"state_topic":    prefix + "/state",
+			"value_template": "{{ 'ON' if value_json.playing else 'OFF' }}",
+		}),
+		entity("sensor", "today", map[string]any{
+			"name":                     "Played today",
+			"state_topic":              prefix + "/today",
+			"value_template":           "{{ value_json.total_seconds | round(0) }}",
+			"unit_of_measurement":      "s",
+			"device_class":             "duration",
+			"state_class":              "total_increasing",
+			"json_attributes_topic":    prefix + "/today",
+			"json_attributes_template": "{{ value_json.games | tojson }}",
+		}),
+	}
+}
+
+// runMQTT keeps a broker connection while MQTT is configured, reconnecting
+// with backoff when it drops.
+func runMQTT(ctx context.Context, p *MQTTPublisher) {
+	backoff := time.Second
+	for {
+		mc := cfg.Load().MQTT
+		if mc.Broker != "" {
+			err := p.publish(ctx, mc)
+			if err == nil {
+				backoff = time.Second
+			} else {
+				log.Printf("MQTT: %v, reconnecting in %s", err, backoff)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+			if mc.Broker != "" {
+				backoff = min(backoff*2, 5*time.Minute)
+			}
+		}
+	}
+}
+
+// publish runs one broker connection until it fails, the context ends or the
+// MQTT config changes.
+func (p *MQTTPublisher) publish(ctx context.Context, mc MQTTConfig) error {
+	prefix := mc.topic()
+	availability := prefix + "/availability"
+	client, err := dialMQTT(mc.Broker, "dazuukiknie-"+machineID(), mc.Username, mc.Password,
+		mqttMessage{Topic: availability, Payload: []byte("offline")})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	log.Printf("MQTT connected to %s", mc.Broker)
+
+	var msgs []mqttMessage
+	if mc.Discovery {
+		msgs = discoveryMessages(prefix, mc.DiscoveryPrefix, deviceName(cfg.Load()))
+	}
+	msgs = append(msgs, mqttMessage{Topic: availability, Payload: []byte("online")})
+	msgs = append(msgs, p.messages(prefix)...)
+	if err := publishAll(client, msgs); err != nil {
+		return err
+	}
+
+	// Totals grow while playing, and the broker wants to hear from us anyway
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return client.Publish(mqttMessage{Topic: availability, Payload: []byte("offline")})
+		case <-client.Dead():
+			return client.err
+		case <-p.changed:
+			if err := publishAll(client, p.messages(prefix)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if cfg.Load().MQTT != mc {
+				log.Printf("MQTT config changed, reconnecting")
+				return client.Publish(mqttMessage{Topic: availability, Payload: []byte("offline")})
+			}
+			if err := publishAll(client, p.messages(prefix)); err != nil {
+				return err
+			}
+			if err := client.Ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func publishAll(c *mqttClient, msgs []mqttMessage) error {
+	for _, m := range msgs {
+		if err := c.Publish(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// NetworkState is what the OS reports about the network connection.
+type NetworkState struct {
+	Known   bool // false when the OS can't tell; reporting carries on as usual
+	Online  bool
+	Metered bool
+}
+
+// allowsReporting reports whether sessions should be sent on this connection.
+func (s NetworkState) allowsReporting(c *Config) bool {
+	if !s.Known {
+		return true
+	}
+	return s.Online && (!s.Metered || c.ReportOnMetered)
+}
+
+func (s NetworkState) String() string {
+	switch {
+	case !s.Known:
+		return "unknown"
+	case !s.Online:
+		return "offline"
+	case s.Metered:
+		return "metered"
+	}
+	return "online"
+}
+
+// runNetworkMonitor calls onChange with the network state whenever it
+// changes, starting with the current one.
+func runNetworkMonitor(ctx context.Context, onChange func(NetworkState)) {
+	states := make(chan NetworkState, 1)
+	go func() {
+		if err := watchNetwork(ctx, states); err != nil {
+			log.Printf("Network state unavailable (%v), reporting regardless", err)
+		}
+	}()
+
+	var last NetworkState
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-states:
+			if s == last {
+				continue
+			}
+			log.Printf("Network is %s", s)
+			last = s
+			onChange(s)
+		}
+	}
+}
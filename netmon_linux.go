@@ -0,0 +1,76 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// NetworkManager states and metered values, see NMState and NMMetered.
+const (
+	nmStateConnectedSite = 60
+	nmMeteredYes         = 1
+	nmMeteredGuessYes    = 3
+)
+
+// watchNetwork follows NetworkManager's state over the system bus.
+func watchNetwork(ctx context.Context, states chan<- NetworkState) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	const path = dbus.ObjectPath("/org/freedesktop/NetworkManager")
+	obj := conn.Object("org.freedesktop.NetworkManager", path)
+	read := func() (NetworkState, error) {
+		var state, metered uint32
+		if err := obj.StoreProperty("org.freedesktop.NetworkManager.State", &state); err != nil {
+			return NetworkState{}, err
+		}
+		if err := obj.StoreProperty("org.freedesktop.NetworkManager.Metered", &metered); err != nil {
+			return NetworkState{}, err
+		}
+		return NetworkState{
+			Known:   true,
+			Online:  state >= nmStateConnectedSite,
+			Metered: metered == nmMeteredYes || metered == nmMeteredGuessYes,
+		}, nil
+	}
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	s, err := read()
+	if err != nil {
+		return err
+	}
+	states <- s
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-signals:
+			if !ok {
+				return nil
+			}
+			if s, err := read(); err == nil {
+				select {
+				case states <- s:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNetworkStateAllowsReporting(t *testing.T) {
+	tests := []struct {
+		state   NetworkState
+		metered bool // report_on_metered
+		want    bool
+	}{
+		{NetworkState{}, false, true},
+		{NetworkState{Known: true}, false, false},
+		{NetworkState{Known: true, Online: true}, false, true},
+		{NetworkState{Known: true, Online: true, Metered: true}, false, false},
+		{NetworkState{Known: true, Online: true, Metered: true}, true, true},
+		{NetworkState{Known: true, Metered: true}, true, false},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.ReportOnMetered = tt.metered
+		if got := tt.state.allowsReporting(c); got != tt.want {
+			t.Errorf("%s with report_on_metered=%v: allowsReporting = %v, want %v", tt.state, tt.metered, got, tt.want)
+		}
+	}
+}
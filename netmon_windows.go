@@ -0,0 +1,59 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procGetNetworkConnectivityHint = syscall.NewLazyDLL("iphlpapi.dll").NewProc("GetNetworkConnectivityHint")
+
+// nlNetworkConnectivityHint is NL_NETWORK_CONNECTIVITY_HINT.
+type nlNetworkConnectivityHint struct {
+	ConnectivityLevel    int32
+	ConnectivityCost     int32
+	ApproachingDataLimit byte
+	OverDataLimit        byte
+	Roaming              byte
+}
+
+const (
+	connectivityLevelNone    = 1
+	connectivityLevelLocal   = 2
+	connectivityCostFixed    = 2
+	connectivityCostVariable = 3
+)
+
+// watchNetwork polls the connectivity hint Windows keeps for the network icon
+// (Windows 10 2004 and later).
+func watchNetwork(ctx context.Context, states chan<- NetworkState) error {
+	if err := procGetNetworkConnectivityHint.Find(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		var hint nlNetworkConnectivityHint
+		if status, _, _ := procGetNetworkConnectivityHint.Call(uintptr(unsafe.Pointer(&hint))); status == 0 && hint.ConnectivityLevel != 0 {
+			s := NetworkState{
+				Known:  true,
+				Online: hint.ConnectivityLevel != connectivityLevelNone && hint.ConnectivityLevel != connectivityLevelLocal,
+				Metered: hint.ConnectivityCost == connectivityCostFixed || hint.ConnectivityCost == connectivityCostVariable ||
+					hint.OverDataLimit != 0 || hint.Roaming != 0,
+			}
+			select {
+			case states <- s:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbox keeps reported sessions per sink until that sink has accepted them,
+// so a sink that is down neither holds back the others nor makes them receive
+// sessions twice.
+type Outbox struct {
+	dir   string
+	clock Clock
+	send  func(context.Context, SinkConfig, []Session) (int, error)
+
+	mu     sync.Mutex
+	queues map[string]*sinkQueue
+	// retries is signalled when a sink gets a new retry time.
+	retries chan struct{}
+}
+
+type sinkQueue struct {
+	sending  sync.Mutex // one request per sink at a time
+	pending  []Session
+	failures int
+	retryAt  time.Time
+}
+
+func newOutbox(dir string, clock Clock) *Outbox {
+	_ = os.MkdirAll(dir, 0755)
+	return &Outbox{
+		dir:     dir,
+		clock:   clock,
+		send:    sendToSink,
+		queues:  make(map[string]*sinkQueue),
+		retries: make(chan struct{}, 1),
+	}
+}
+
+// Orphans returns the sinks that still have a queue on disk but are no longer
+// configured. Their sessions are only sent once a sink of that name returns.
+func (o *Outbox) Orphans(sinks []SinkConfig) map[string]int {
+	files, _ := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	orphans := make(map[string]int)
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		if slices.ContainsFunc(sinks, func(s SinkConfig) bool { return s.Name == name }) {
+			continue
+		}
+		o.mu.Lock()
+		orphans[name] = len(o.queue(name).pending)
+		o.mu.Unlock()
+	}
+	return orphans
+}
+
+// NextRetry returns how long until the earliest backed-off sink with queued
+// sessions may be retried, or false if none is waiting.
+func (o *Outbox) NextRetry(sinks []SinkConfig) (time.Duration, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var next time.Time
+	for _, s := range sinks {
+		q := o.queue(s.Name)
+		if len(q.pending) > 0 && !q.retryAt.IsZero() && (next.IsZero() || q.retryAt.Before(next)) {
+			next = q.retryAt
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(next.Sub(o.clock.Now()), 0), true
+}
+
+// Retries is signalled whenever a failed delivery schedules a retry.
+func (o *Outbox) Retries() <-chan struct{} {
+	return o.retries
+}
+
+// Add queues sessions for every sink.
+func (o *Outbox) Add(sinks []SinkConfig, sessions []Session) {
+	if len(sessions) == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range sinks {
+		q := o.queue(s.Name)
+		q.pending = append(q.pending, sessions...)
+		o.save(s.Name, q)
+	}
+}
+
+func (o *Outbox) HasPending(sinks []SinkConfig) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range sinks {
+		if len(o.queue(s.Name).pending) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is what one Deliver call did for a sink.
+type Delivery struct {
+	Sink string
+	Sent int
+	// Busy is set when another delivery was already sending to the sink,
+	// which this one didn't wait for.
+	Busy bool
+	Err  error
+}
+
+func (d Delivery) String() string {
+	switch {
+	case d.Busy:
+		return d.Sink + ": already sending"
+	case d.Err != nil:
+		return fmt.Sprintf("%s: failed, %v", d.Sink, d.Err)
+	case d.Sent > 0:
+		return fmt.Sprintf("%s: sent %d session(s)", d.Sink, d.Sent)
+	}
+	return d.Sink + ": nothing sent"
+}
+
+// Deliver sends every sink its queued sessions in parallel. Sinks that failed
+// recently are skipped until their backoff passes, unless force is set.
+func (o *Outbox) Deliver(ctx context.Context, sinks []SinkConfig, force bool) ([]Delivery, error) {
+	deliveries := make([]Delivery, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliveries[i] = o.deliver(ctx, s, force)
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, d := range deliveries {
+		if d.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.Sink, d.Err))
+		}
+	}
+	return deliveries, errors.Join(errs...)
+}
+
+func (o *Outbox) deliver(ctx context.Context, sink SinkConfig, force bool) Delivery {
+	d := Delivery{Sink: sink.Name}
+	o.mu.Lock()
+	q := o.queue(sink.Name)
+	o.mu.Unlock()
+	if !q.sending.TryLock() {
+		d.Busy = true
+		return d
+	}
+	defer q.sending.Unlock()
+
+	o.mu.Lock()
+	if len(q.pending) == 0 || (!force && o.clock.Now().Before(q.retryAt)) {
+		o.mu.Unlock()
+		return d
+	}
+	batch := slices.Clone(q.pending)
+	o.mu.Unlock()
+
+	sent, err := o.send(ctx, sink, batch)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Only appends happen while sending, so the batch is still the head of the queue
+	q.pending = q.pending[sent:]
+	o.save(sink.Name, q)
+	if d.Sent = sent; sent > 0 {
+		log.Printf("Sent %d session(s) to %s", sent, sink.Name)
+	}
+	if err != nil {
+		q.failures++
+		backoff := min(time.Minute<<min(q.failures-1, 6), time.Hour)
+		q.retryAt = o.clock.Now().Add(backoff)
+		select {
+		case o.retries <- struct{}{}:
+		default:
+		}
+		d.Err = fmt.Errorf("%w (retry in %s)", err, backoff)
+		return d
+	}
+	q.failures, q.retryAt = 0, time.Time{}
+	return d
+}
+
+// queue returns the queue for a sink, loading it from disk on first use.
+// Must be called with o.mu held.
+func (o *Outbox) queue(name string) *sinkQueue {
+	if q, ok := o.queues[name]; ok {
+		return q
+	}
+	q := &sinkQueue{}
+	o.queues[name] = q
+	data, err := os.ReadFile(o.path(name))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("outbox load %s: %v", name, err)
+		}
+		return q
+	}
+	if err := json.Unmarshal(data, &q.pending); err != nil {
+		log.Printf("outbox parse %s: %v", name, err)
+	} else if len(q.pending) > 0 {
+		log.Printf("Loaded %d unsent sessions for %s from disk", len(q.pending), name)
+	}
+	return q
+}
+
+func (o *Outbox) save(name string, q *sinkQueue) {
+	if len(q.pending) == 0 {
+		if err := os.Remove(o.path(name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("outbox remove %s: %v", name, err)
+		}
+		return
+	}
+	data, err := json.MarshalIndent(q.pending, "", "  ")
+	if err != nil {
+		log.Printf("outbox save %s: %v", name, err)
+		return
+	}
+	if err := os.WriteFile(o.path(name), data, 0644); err != nil {
+		log.Printf("outbox write %s: %v", name, err)
+	}
+}
+
+func (o *Outbox) path(name string) string {
+	return filepath.Join(o.dir, name+".json")
+}
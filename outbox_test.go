@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	clock := newFakeClock()
+	dir := t.TempDir()
+	o := newOutbox(dir, clock)
+	var attempts int
+	o.send = func(context.Context, SinkConfig, []Session) (int, error) {
+		attempts++
+		return 0, errors.New("down")
+	}
+	sinks := []SinkConfig{{Name: "dash", URL: "https://example.com"}}
+	o.Add(sinks, []Session{{Game: Game{Name: "Factorio"}, Duration: 60}})
+
+	o.Deliver(context.Background(), sinks, false)
+	clock.Advance(30 * time.Second)
+	o.Deliver(context.Background(), sinks, false)
+	if attempts != 1 {
+		t.Fatalf("retried during backoff: %d attempts", attempts)
+	}
+	select {
+	case <-o.Retries():
+	default:
+		t.Error("failed delivery did not signal a retry")
+	}
+	if d, ok := o.NextRetry(sinks); !ok || d != 30*time.Second {
+		t.Errorf("next retry in %s, %v; want 30s", d, ok)
+	}
+	clock.Advance(time.Minute)
+	o.Deliver(context.Background(), sinks, false)
+	if attempts != 2 {
+		t.Fatalf("not retried after backoff: %d attempts", attempts)
+	}
+
+	// The queue survives a restart
+	o = newOutbox(dir, clock)
+	o.send = func(_ context.Context, _ SinkConfig, sessions []Session) (int, error) { return len(sessions), nil }
+	if !o.HasPending(sinks) {
+		t.Fatal("queued session lost on restart")
+	}
+	if _, err := o.Deliver(context.Background(), sinks, false); err != nil || o.HasPending(sinks) {
+		t.Fatalf("deliver after restart: %v", err)
+	}
+}
+
+func TestOutboxOrphans(t *testing.T) {
+	o := newOutbox(t.TempDir(), newFakeClock())
+	o.send = func(context.Context, SinkConfig, []Session) (int, error) { return 0, errors.New("down") }
+	old := []SinkConfig{{Name: "dash", URL: "https://example.com"}, {Name: "backup", URL: "https://example.org"}}
+	o.Add(old, []Session{{Game: Game{Name: "Factorio"}, Duration: 60}})
+
+	renamed := []SinkConfig{{Name: "dashboard", URL: "https://example.com"}, old[1]}
+	o = newOutbox(o.dir, o.clock)
+	orphans := o.Orphans(renamed)
+	if len(orphans) != 1 || orphans["dash"] != 1 {
+		t.Errorf("orphans = %v, want dash with 1 session", orphans)
+	}
+	if _, ok := o.NextRetry(renamed); ok {
+		t.Error("retry scheduled without a failed delivery")
+	}
+}
+
+func TestOutboxDeliveries(t *testing.T) {
+	o := newOutbox(t.TempDir(), newFakeClock())
+	started, release := make(chan struct{}), make(chan struct{})
+	o.send = func(_ context.Context, sink SinkConfig, sessions []Session) (int, error) {
+		switch sink.Name {
+		case "slow":
+			close(started)
+			<-release
+		case "down":
+			return 0, errors.New("down")
+		}
+		return len(sessions), nil
+	}
+	sinks := []SinkConfig{{Name: "slow"}, {Name: "up"}, {Name: "down"}, {Name: "empty"}}
+	o.Add(sinks[:3], []Session{{Game: Game{Name: "Factorio"}, Duration: 60}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.Deliver(context.Background(), sinks[:1], true)
+	}()
+	<-started
+	deliveries, err := o.Deliver(context.Background(), sinks, true)
+	close(release)
+	<-done
+
+	if err == nil || !strings.HasPrefix(err.Error(), "down: down") {
+		t.Errorf("error %v, want the down sink's", err)
+	}
+	var got []string
+	for _, d := range deliveries {
+		got = append(got, d.String())
+	}
+	want := []string{"slow: already sending", "up: sent 1 session(s)", "down: failed, down (retry in 1m0s)", "empty: nothing sent"}
+	if !slices.Equal(got, want) {
+		t.Errorf("deliveries:\n%q\nwant\n%q", got, want)
+	}
+}
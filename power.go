@@ -0,0 +1,8 @@
+package main
+
+// powerEvent announces that the machine is about to sleep or shut down. The
+// OS waits for done, within limits, so the session can be saved first.
+type powerEvent struct {
+	shutdown bool
+	done     func()
+}
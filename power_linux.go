@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// powerEvents reports logind's sleep and shutdown announcements. An inhibitor
+// lock delays them until the event is done; without logind it returns nil.
+func powerEvents(ctx context.Context) <-chan powerEvent {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		log.Printf("logind unavailable (%v), noticing sleep from detection gaps only", err)
+		return nil
+	}
+	const path = dbus.ObjectPath("/org/freedesktop/login1")
+	login := conn.Object("org.freedesktop.login1", path)
+	inhibit := func() *os.File {
+		var fd dbus.UnixFD
+		err := login.Call("org.freedesktop.login1.Manager.Inhibit", 0,
+			"sleep:shutdown", "dazuukiknie", "Saving the current game session", "delay").Store(&fd)
+		if err != nil {
+			log.Printf("logind inhibitor lock: %v", err)
+			return nil
+		}
+		return os.NewFile(uintptr(fd), "inhibitor")
+	}
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+	)
+	if err != nil {
+		log.Printf("logind signals: %v", err)
+		conn.Close()
+		return nil
+	}
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+
+	events := make(chan powerEvent)
+	go func() {
+		defer conn.Close()
+		lock := inhibit()
+		for {
+			var sig *dbus.Signal
+			select {
+			case <-ctx.Done():
+				return
+			case sig = <-signals:
+			}
+			if len(sig.Body) != 1 {
+				continue
+			}
+			starting, _ := sig.Body[0].(bool)
+			var e powerEvent
+			switch sig.Name {
+			case "org.freedesktop.login1.Manager.PrepareForSleep":
+			case "org.freedesktop.login1.Manager.PrepareForShutdown":
+				e.shutdown = true
+			default:
+				continue
+			}
+			if !starting {
+				// Woke up, or the shutdown was cancelled: hold the next one again
+				if lock == nil {
+					lock = inhibit()
+				}
+				continue
+			}
+
+			done := make(chan struct{})
+			e.done = func() { close(done) }
+			select {
+			case events <- e:
+				select {
+				case <-done:
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+			}
+			if lock != nil {
+				lock.Close()
+				lock = nil
+			}
+		}
+	}()
+	return events
+}
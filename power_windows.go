@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "context"
+
+// powerEvents returns nil: sleep on Windows is noticed from the gap between
+// detections instead.
+func powerEvents(ctx context.Context) <-chan powerEvent {
+	return nil
+}
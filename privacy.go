@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// genericGameName replaces the name of private games in everything the agent sends.
+const genericGameName = "Game"
+
+// PrivacyConfig limits what leaves the machine about the games played.
+type PrivacyConfig struct {
+	// Exclude lists games that are not tracked at all.
+	Exclude []string `json:"exclude,omitempty"`
+	// Private lists games reported only as a generic "Game".
+	Private []string `json:"private,omitempty"`
+	// Generic reports every game as a generic "Game".
+	Generic bool `json:"generic"`
+	// Process is "keep" (default), "hash" or "drop" for the process name.
+	Process string `json:"process,omitempty"`
+}
+
+func (p PrivacyConfig) excluded(name string) bool {
+	return containsFold(p.Exclude, name)
+}
+
+// game returns what may be reported about g.
+func (p PrivacyConfig) game(g Game) Game {
+	if p.Generic || containsFold(p.Private, g.Name) {
+		return Game{Name: genericGameName, Source: g.Source}
+	}
+	switch p.Process {
+	case "hash":
+		if g.Process != "" {
+			// Salted with a local secret so a list of known names can't be matched
+			h := sha256.Sum256([]byte(hashSalt() + "\x00" + strings.ToLower(g.Process)))
+			g.Process = fmt.Sprintf("%x", h[:8])
+		}
+	case "drop":
+		g.Process = ""
+	}
+	return g
+}
+
+// sessions drops sessions of excluded games and strips the rest down to what
+// may be reported.
+func (p PrivacyConfig) sessions(sessions []Session) []Session {
+	out := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		if p.excluded(s.Game.Name) {
+			continue
+		}
+		s.Game = p.game(s.Game)
+		out = append(out, s)
+	}
+	return out
+}
+
+// detected is game for live updates, nil when g must not be shown.
+func (p PrivacyConfig) detected(g *DetectedGame) *DetectedGame {
+	if g == nil || p.excluded(g.Name) {
+		return nil
+	}
+	public := p.game(g.game())
+	d := &DetectedGame{
+		Name:       public.Name,
+		Source:     public.Source,
+		SteamAppID: public.SteamAppID,
+		Process:    public.Process,
+		Focused:    g.Focused,
+	}
+	// PIDs go along only where the process itself may be named
+	if public.Process != "" && public.Process == g.Process {
+		d.PIDs = g.PIDs
+	}
+	return d
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool { return strings.EqualFold(v, s) })
+}
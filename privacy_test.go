@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPrivacySessions(t *testing.T) {
+	sessions := []Session{
+		{Game: Game{Name: "Factorio", Source: "config", Process: "factorio"}},
+		{Game: Game{Name: "Secret Game", Source: "steam", SteamAppID: 42, Process: "secret"}},
+		{Game: Game{Name: "Counter-Strike 2", Source: "steam", SteamAppID: 730, Process: "cs2"}},
+	}
+	tests := []struct {
+		name    string
+		privacy PrivacyConfig
+		want    []Game
+	}{
+		{
+			name: "keep",
+			want: []Game{sessions[0].Game, sessions[1].Game, sessions[2].Game},
+		},
+		{
+			name:    "exclude and private",
+			privacy: PrivacyConfig{Exclude: []string{"factorio"}, Private: []string{"Secret Game"}, Process: "drop"},
+			want: []Game{
+				{Name: "Game", Source: "steam"},
+				{Name: "Counter-Strike 2", Source: "steam", SteamAppID: 730},
+			},
+		},
+		{
+			name:    "generic",
+			privacy: PrivacyConfig{Generic: true},
+			want:    []Game{{Name: "Game", Source: "config"}, {Name: "Game", Source: "steam"}, {Name: "Game", Source: "steam"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.privacy.sessions(sessions)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d sessions, want %d", len(got), len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i].Game != tt.want[i] {
+					t.Errorf("session %d: got %+v, want %+v", i, got[i].Game, tt.want[i])
+				}
+			}
+		})
+	}
+
+	hashed := PrivacyConfig{Process: "hash"}.game(sessions[0].Game)
+	if hashed.Process == "" || hashed.Process == "factorio" || hashed.Name != "Factorio" {
+		t.Errorf("process not hashed: %+v", hashed)
+	}
+	// The machine ID is sent along, so it must not be the salt
+	if h := sha256.Sum256([]byte(machineID() + "\x00factorio")); hashed.Process == fmt.Sprintf("%x", h[:8]) {
+		t.Error("process hash is salted with the machine ID")
+	}
+}
+
+func TestPrivacyDetectedPIDs(t *testing.T) {
+	g := &DetectedGame{Name: "Counter-Strike 2", Source: "steam", SteamAppID: 730, Process: "cs2", PIDs: []int{42, 40}}
+	for _, tt := range []struct {
+		privacy PrivacyConfig
+		want    bool
+	}{
+		{PrivacyConfig{}, true},
+		{PrivacyConfig{Process: "keep"}, true},
+		{PrivacyConfig{Process: "hash"}, false},
+		{PrivacyConfig{Process: "drop"}, false},
+		{PrivacyConfig{Private: []string{"Counter-Strike 2"}}, false},
+	} {
+		if got := tt.privacy.detected(g).PIDs != nil; got != tt.want {
+			t.Errorf("%+v: pids reported %v, want %v", tt.privacy, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerPauseAndExclude(t *testing.T) {
+	h := newTrackerHarness(t)
+	h.cfg.Privacy.Exclude = []string{"Counter-Strike 2"}
+
+	h.procs.window = "factorio"
+	h.tickAt(0)
+	h.tracker.SetPaused(true)
+	h.tickAt(10 * time.Minute)
+	h.tracker.SetPaused(false)
+	h.tickAt(20 * time.Minute)
+	h.procs.window, h.procs.steamAppID = "", 730
+	h.tickAt(30 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", "", "Factorio", ""})
+	h.buf.Flush()
+	assertSessions(t, h.buf.Drain(), []wantSession{
+		{"Factorio", 0, 10 * time.Minute, 10 * time.Minute},
+		{"Factorio", 20 * time.Minute, 30 * time.Minute, 10 * time.Minute},
+	})
+}
@@ -0,0 +1,286 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Netlink process connector, see linux/cn_proc.h and linux/connector.h.
+const (
+	netlinkConnector  = 11
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+
+	procEventNone = 0x00000000
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventComm = 0x00000200
+	procEventExit = 0x80000000
+
+	cnMsgLen     = 20 // struct cn_msg without data
+	procEventHdr = 16 // what, cpu, timestamp_ns
+)
+
+// procEventWatcher keeps a table of the user's processes current from exec
+// and exit events, so only new processes get inspected and game starts and
+// stops are seen the moment they happen.
+type procEventWatcher struct {
+	fd      int
+	mu      sync.Mutex
+	procs   map[int]procInfo
+	dead    bool
+	changes chan struct{}
+}
+
+// procWatcher returns the running watcher, or nil when the process connector
+// isn't available (it requires CAP_NET_ADMIN) and /proc has to be polled.
+var procWatcher = sync.OnceValue(func() *procEventWatcher {
+	w, err := startProcWatcher()
+	if err != nil {
+		log.Printf("Process events unavailable (%v), polling /proc", err)
+		return nil
+	}
+	log.Printf("Watching process events via netlink")
+	return w
+})
+
+// processEvents returns a channel that fires when one of the user's processes
+// starts or exits, or nil when processes are polled.
+func processEvents() <-chan struct{} {
+	if w := procWatcher(); w != nil {
+		return w.changes
+	}
+	return nil
+}
+
+func startProcWatcher() (*procEventWatcher, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkConnector)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	if err := subscribeProcEvents(fd); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Subscribe first and scan second, so no process slips between the two
+	procs, err := scanProcesses()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	w := &procEventWatcher{fd: fd, procs: procs, changes: make(chan struct{}, 1)}
+	go w.run()
+	return w, nil
+}
+
+func subscribeProcEvents(fd int) error {
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		return fmt.Errorf("netlink bind: %w", err)
+	}
+
+	// nlmsghdr + cn_msg + PROC_CN_MCAST_LISTEN
+	msg := make([]byte, syscall.NLMSG_HDRLEN+cnMsgLen+4)
+	ne := binary.NativeEndian
+	ne.PutUint32(msg[0:], uint32(len(msg)))
+	ne.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	ne.PutUint32(msg[12:], uint32(os.Getpid()))
+	cn := msg[syscall.NLMSG_HDRLEN:]
+	ne.PutUint32(cn[0:], cnIdxProc)
+	ne.PutUint32(cn[4:], cnValProc)
+	ne.PutUint16(cn[16:], 4)
+	ne.PutUint32(cn[cnMsgLen:], procCnMcastListen)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("netlink listen: %w", err)
+	}
+
+	// The kernel acks the request, with EPERM for unprivileged callers
+	tv := syscall.NsecToTimeval(int64(2 * time.Second))
+	_ = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	defer func() {
+		tv := syscall.Timeval{}
+		_ = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	}()
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("netlink ack: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("netlink ack: %w", err)
+		}
+		for _, m := range msgs {
+			ev := m.Data
+			if len(ev) < cnMsgLen+procEventHdr+4 || ne.Uint32(ev[cnMsgLen:]) != procEventNone {
+				continue
+			}
+			if errno := ne.Uint32(ev[cnMsgLen+procEventHdr:]); errno != 0 {
+				return fmt.Errorf("netlink listen: %w", syscall.Errno(errno))
+			}
+			return nil
+		}
+	}
+}
+
+func (w *procEventWatcher) run() {
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(w.fd, buf, 0)
+		if errors.Is(err, syscall.ENOBUFS) {
+			// Events were dropped; start over from a full scan
+			if procs, err := scanProcesses(); err == nil {
+				w.mu.Lock()
+				w.procs = procs
+				w.mu.Unlock()
+				w.notify()
+			}
+			continue
+		}
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Process events failed (%v), polling /proc", err)
+			w.mu.Lock()
+			w.dead = true
+			w.mu.Unlock()
+			syscall.Close(w.fd)
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			w.handleMessage(m.Data)
+		}
+	}
+}
+
+// handleMessage applies a struct cn_msg carrying a proc_event.
+func (w *procEventWatcher) handleMessage(msg []byte) {
+	ne := binary.NativeEndian
+	if len(msg) < cnMsgLen+procEventHdr || ne.Uint32(msg[0:]) != cnIdxProc || ne.Uint32(msg[4:]) != cnValProc {
+		return
+	}
+	w.handle(msg[cnMsgLen:])
+}
+
+// handle applies a single struct proc_event to the table.
+func (w *procEventWatcher) handle(ev []byte) {
+	ne := binary.NativeEndian
+	what := ne.Uint32(ev)
+	data := ev[procEventHdr:]
+
+	switch what {
+	case procEventFork:
+		if len(data) < 16 {
+			return
+		}
+		parent, child, childTgid := int(ne.Uint32(data[4:])), int(ne.Uint32(data[8:])), int(ne.Uint32(data[12:]))
+		if child != childTgid {
+			return // new thread, not a process
+		}
+		// A forked child shares its parent's name and environment until it execs
+		w.mu.Lock()
+		if p, ok := w.procs[parent]; ok {
+			w.procs[child] = p
+		}
+		w.mu.Unlock()
+
+	case procEventExec:
+		if len(data) < 8 {
+			return
+		}
+		pid := int(ne.Uint32(data[4:]))
+		p, ok := inspectProcess(pid)
+		w.mu.Lock()
+		if ok {
+			w.procs[pid] = p
+		} else {
+			delete(w.procs, pid)
+		}
+		w.mu.Unlock()
+		if ok {
+			w.notify()
+		}
+
+	case procEventComm:
+		if len(data) < 24 {
+			return
+		}
+		pid, tgid := int(ne.Uint32(data[0:])), int(ne.Uint32(data[4:]))
+		if pid != tgid {
+			return // thread renamed itself
+		}
+		comm := string(data[8:24])
+		if i := strings.IndexByte(comm, 0); i >= 0 {
+			comm = comm[:i]
+		}
+		w.mu.Lock()
+		_, ok := w.procs[pid]
+		w.mu.Unlock()
+		if !ok {
+			return
+		}
+		// The name may hinge on comm, e.g. when a Wine loader renames itself
+		name, wrapper := describeProcess(pid, comm)
+		w.mu.Lock()
+		if p, ok := w.procs[pid]; ok {
+			p.comm, p.name, p.wrapper = comm, name, wrapper
+			w.procs[pid] = p
+		}
+		w.mu.Unlock()
+
+	case procEventExit:
+		if len(data) < 8 {
+			return
+		}
+		pid, tgid := int(ne.Uint32(data[0:])), int(ne.Uint32(data[4:]))
+		if pid != tgid {
+			return // a thread exited
+		}
+		w.mu.Lock()
+		_, ok := w.procs[pid]
+		delete(w.procs, pid)
+		w.mu.Unlock()
+		if ok {
+			w.notify()
+		}
+	}
+}
+
+func (w *procEventWatcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (w *procEventWatcher) alive() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.dead
+}
+
+func (w *procEventWatcher) snapshot() map[int]procInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.procs)
+}
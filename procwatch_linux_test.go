@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// cnProcMsg builds a struct cn_msg holding a proc_event of type what with the
+// given event data.
+func cnProcMsg(what uint32, data ...uint32) []byte {
+	ne := binary.NativeEndian
+	msg := make([]byte, cnMsgLen+procEventHdr)
+	ne.PutUint32(msg[0:], cnIdxProc)
+	ne.PutUint32(msg[4:], cnValProc)
+	ne.PutUint32(msg[cnMsgLen:], what)
+	for _, v := range data {
+		msg = ne.AppendUint32(msg, v)
+	}
+	ne.PutUint16(msg[16:], uint16(len(msg)-cnMsgLen))
+	return msg
+}
+
+func cnCommMsg(pid int, comm string) []byte {
+	msg := cnProcMsg(procEventComm, uint32(pid), uint32(pid))
+	var name [16]byte
+	copy(name[:], comm)
+	return append(msg, name[:]...)
+}
+
+func TestProcEventHandling(t *testing.T) {
+	w := &procEventWatcher{procs: make(map[int]procInfo), changes: make(chan struct{}, 1)}
+	notified := func() bool {
+		select {
+		case <-w.changes:
+			return true
+		default:
+			return false
+		}
+	}
+	self := os.Getpid()
+
+	// exec: process_pid, process_tgid; inspected from /proc
+	w.handleMessage(cnProcMsg(procEventExec, uint32(self), uint32(self)))
+	if _, ok := w.snapshot()[self]; !ok || !notified() {
+		t.Fatalf("exec of %d not recorded", self)
+	}
+
+	// fork: parent pid/tgid, child pid/tgid; the child inherits the entry
+	w.handleMessage(cnProcMsg(procEventFork, uint32(self), uint32(self), 900001, 900001))
+	// a new thread is not a process
+	w.handleMessage(cnProcMsg(procEventFork, uint32(self), uint32(self), 900002, uint32(self)))
+	procs := w.snapshot()
+	if procs[900001] != procs[self] {
+		t.Errorf("forked child %+v, want a copy of %+v", procs[900001], procs[self])
+	}
+	if _, ok := procs[900002]; ok {
+		t.Error("thread recorded as a process")
+	}
+
+	w.handleMessage(cnCommMsg(900001, "renamed"))
+	if got := w.snapshot()[900001].comm; got != "renamed" {
+		t.Errorf("comm %q after rename, want renamed", got)
+	}
+
+	// exit: process_pid, process_tgid, exit_code, exit_signal
+	w.handleMessage(cnProcMsg(procEventExit, 900001, 900001, 0, 17))
+	if _, ok := w.snapshot()[900001]; ok || !notified() {
+		t.Error("exit not applied")
+	}
+	// a thread exiting leaves the process alone
+	w.handleMessage(cnProcMsg(procEventExit, 900003, uint32(self), 0, 0))
+	if _, ok := w.snapshot()[self]; !ok || notified() {
+		t.Error("thread exit removed the process")
+	}
+
+	// truncated and foreign messages are ignored
+	w.handleMessage(cnProcMsg(procEventExit, 1)[:cnMsgLen+4])
+	foreign := cnProcMsg(procEventExit, uint32(self), uint32(self), 0, 0)
+	binary.NativeEndian.PutUint32(foreign[0:], 2)
+	w.handleMessage(foreign)
+	if _, ok := w.snapshot()[self]; !ok {
+		t.Error("ignored message removed the process")
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// promptText asks the user for a line of text with zenity or kdialog. ok is
+// false when the dialog was cancelled.
+func promptText(title, text, initial string) (answer string, ok bool, err error) {
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("zenity"); err == nil {
+		cmd = exec.Command(path, "--entry", "--title", title, "--text", text, "--entry-text", initial)
+	} else if path, err := exec.LookPath("kdialog"); err == nil {
+		cmd = exec.Command(path, "--title", title, "--inputbox", text, initial)
+	} else {
+		return "", false, errors.New("no dialog tool found, install zenity or kdialog")
+	}
+
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", false, nil // cancelled
+	}
+	if err != nil {
+		return "", false, err
+	}
+	answer = strings.TrimSpace(string(out))
+	return answer, answer != "", nil
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// promptText asks the user for a line of text with a VB InputBox. ok is
+// false when the dialog was cancelled.
+func promptText(title, text, initial string) (answer string, ok bool, err error) {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	script := "[Console]::OutputEncoding = [Text.Encoding]::UTF8; " +
+		"Add-Type -AssemblyName Microsoft.VisualBasic; " +
+		"[Microsoft.VisualBasic.Interaction]::InputBox(" + quote(text) + ", " + quote(title) + ", " + quote(initial) + ")"
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false, err
+	}
+	// InputBox returns an empty string when cancelled
+	answer = strings.TrimSpace(string(out))
+	return answer, answer != "", nil
+}
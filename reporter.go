@@ -2,59 +2,169 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"text/template"
 	"time"
 )
 
 type Report struct {
-	MachineID string    `json:"machine_id"`
-	Sessions  []Session `json:"sessions"`
-	SentAt    time.Time `json:"sent_at"`
+	MachineID  string `json:"machine_id"`
+	DeviceName string `json:"device_name,omitempty"`
+	// PreviousMachineID is the legacy ID this device reported as before,
+	// sent until the sink has accepted it once.
+	PreviousMachineID string    `json:"previous_machine_id,omitempty"`
+	Sessions          []Session `json:"sessions"`
+	SentAt            time.Time `json:"sent_at"`
 }
 
-func sendReport(sessions []Session, cfg *Config) error {
+// reportLine is one line of the ndjson format, and what webhook templates
+// are executed on.
+type reportLine struct {
+	MachineID         string `json:"machine_id"`
+	DeviceName        string `json:"device_name,omitempty"`
+	PreviousMachineID string `json:"previous_machine_id,omitempty"`
+	Session
+}
+
+var reportClient = newHTTPClient(10 * time.Second)
+
+// templateFuncs are available to webhook templates.
+var templateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, quoting and escaping strings
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// duration formats seconds as e.g. 1h30m0s
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).String()
+	},
+}
+
+func parseSinkTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// statusError is a response the sink answered with an error status.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("server returned %d", int(e))
+}
+
+// rejected reports whether the sink refused a request for good, so sending
+// the same body again can't succeed. Timeouts and rate limits are retried.
+func rejected(err error) bool {
+	var status statusError
+	return errors.As(err, &status) && status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// sendToSink posts sessions to a single sink in its configured format and
+// returns how many are done with. Sessions the sink rejects are dropped, as
+// sending them again can't succeed. Template sinks post one request per
+// session and also drop sessions that can't be rendered, so one bad session
+// doesn't hold up the rest; the other formats deliver all sessions or none.
+func sendToSink(ctx context.Context, sink SinkConfig, sessions []Session) (int, error) {
 	if len(sessions) == 0 {
-		return nil
+		return 0, nil
 	}
 
-	report := Report{
-		MachineID: machineID(),
-		Sessions:  sessions,
-		SentAt:    time.Now().UTC(),
+	if sink.Format == "template" {
+		tmpl, err := parseSinkTemplate(sink.Template)
+		if err != nil {
+			return 0, err
+		}
+		id, name := machineID(), configuredDeviceName()
+		for i, s := range sessions {
+			var body bytes.Buffer
+			if err := tmpl.Execute(&body, reportLine{MachineID: id, DeviceName: name, Session: s}); err != nil {
+				log.Printf("Dropped %s session for %s: template: %v", s.Game.Name, sink.Name, err)
+				continue
+			}
+			if err := postToSink(ctx, sink, body.Bytes(), "application/json"); rejected(err) {
+				log.Printf("Dropped %s session for %s: %v", s.Game.Name, sink.Name, err)
+			} else if err != nil {
+				return i, err
+			}
+		}
+		return len(sessions), nil
 	}
 
-	data, err := json.Marshal(report)
+	previous := device().previousID(sink.Name)
+	data, contentType, err := encodeSessions(sink.Format, previous, sessions)
 	if err != nil {
-		return fmt.Errorf("marshal: %w", err)
+		return 0, fmt.Errorf("marshal: %w", err)
 	}
+	if err := postToSink(ctx, sink, data, contentType); rejected(err) {
+		log.Printf("Dropped %d session(s) for %s: %v", len(sessions), sink.Name, err)
+		return len(sessions), nil
+	} else if err != nil {
+		return 0, err
+	}
+	if previous != "" {
+		device().markLinked(sink.Name)
+	}
+	return len(sessions), nil
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(cfg.ServerURL, "application/json", bytes.NewReader(data))
+func postToSink(ctx context.Context, sink SinkConfig, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if sink.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sink.Token)
+	}
+	for k, v := range sink.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := reportClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("post: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("server returned %d", resp.StatusCode)
+		return statusError(resp.StatusCode)
 	}
-
-	log.Printf("Sent %d session(s) to server", len(sessions))
 	return nil
 }
 
-// machineID returns a stable anonymous identifier derived from hostname + username.
-func machineID() string {
-	hostname, _ := os.Hostname()
-	username := os.Getenv("USER")
-	if username == "" {
-		username = os.Getenv("USERNAME") // Windows
+func encodeSessions(format, previousID string, sessions []Session) ([]byte, string, error) {
+	id, name := machineID(), configuredDeviceName()
+	if format == "ndjson" {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		for _, s := range sessions {
+			line := reportLine{MachineID: id, DeviceName: name, PreviousMachineID: previousID, Session: s}
+			if err := enc.Encode(line); err != nil {
+				return nil, "", err
+			}
+		}
+		return b.Bytes(), "application/x-ndjson", nil
+	}
+
+	data, err := json.Marshal(Report{
+		MachineID:         id,
+		DeviceName:        name,
+		PreviousMachineID: previousID,
+		Sessions:          sessions,
+		SentAt:            time.Now().UTC(),
+	})
+	return data, "application/json", err
+}
+
+// configuredDeviceName is the device name set in the config, if any.
+func configuredDeviceName() string {
+	if c := cfg.Load(); c != nil {
+		return c.DeviceName
 	}
-	h := sha256.Sum256([]byte(hostname + username))
-	return fmt.Sprintf("%x", h[:8])
+	return ""
 }
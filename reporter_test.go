@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTemplateSink(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Hook"); got != "agent" {
+			t.Errorf("header X-Hook = %q", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		bodies = append(bodies, string(body))
+	}))
+	defer srv.Close()
+
+	sink := SinkConfig{
+		Name:     "discord",
+		URL:      srv.URL,
+		Format:   "template",
+		Template: `{"content": {{json (printf "%s played %s" .MachineID .Game.Name)}}, "time": "{{duration .Duration}}"}`,
+		Headers:  map[string]string{"X-Hook": "agent"},
+	}
+	sessions := []Session{
+		{Game: Game{Name: `"Factorio"`}, StartedAt: testEpoch, EndedAt: testEpoch.Add(time.Hour), Duration: 5400},
+		{Game: Game{Name: "RimWorld"}, Duration: 60},
+	}
+
+	sent, err := sendToSink(context.Background(), sink, sessions)
+	if sent != 1 || err == nil {
+		t.Fatalf("sent %d, err %v; want 1 and the second request to fail", sent, err)
+	}
+	want := `{"content": "` + machineID() + ` played \"Factorio\"", "time": "1h30m0s"}`
+	if len(bodies) != 1 || bodies[0] != want {
+		t.Errorf("got bodies %q, want %q", bodies, want)
+	}
+}
+
+func TestTemplateSinkDropsRejectedSessions(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "Bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, string(body))
+	}))
+	defer srv.Close()
+
+	sink := SinkConfig{Name: "hook", URL: srv.URL, Format: "template", Template: `{{.Game.Name}}{{if .Game.SteamAppID}}{{.Nope}}{{end}}`}
+	sessions := []Session{
+		{Game: Game{Name: "Broken", SteamAppID: 730}}, // fails to render
+		{Game: Game{Name: "Bad"}},                     // rejected by the sink
+		{Game: Game{Name: "Factorio"}},
+	}
+	sent, err := sendToSink(context.Background(), sink, sessions)
+	if sent != 3 || err != nil {
+		t.Fatalf("sent %d, err %v; want 3 and no error", sent, err)
+	}
+	if len(bodies) != 1 || bodies[0] != "Factorio" {
+		t.Errorf("got bodies %q, want only Factorio", bodies)
+	}
+}
+
+func TestReportSinkDropsRejectedBatch(t *testing.T) {
+	status := http.StatusUnprocessableEntity
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sessions := []Session{{Game: Game{Name: "Factorio"}}, {Game: Game{Name: "RimWorld"}}}
+	for _, format := range []string{"report", "ndjson"} {
+		sink := SinkConfig{Name: "dash", URL: srv.URL, Format: format}
+		status = http.StatusUnprocessableEntity
+		if sent, err := sendToSink(context.Background(), sink, sessions); sent != 2 || err != nil {
+			t.Errorf("%s, rejected: sent %d, err %v; want the batch dropped", format, sent, err)
+		}
+		status = http.StatusServiceUnavailable
+		if sent, err := sendToSink(context.Background(), sink, sessions); sent != 0 || err == nil {
+			t.Errorf("%s, unavailable: sent %d, err %v; want a retry", format, sent, err)
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{statusError(400), true},
+		{statusError(404), true},
+		{fmt.Errorf("hook: %w", statusError(422)), true},
+		{statusError(408), false},
+		{statusError(429), false},
+		{statusError(500), false},
+		{errors.New("post: connection refused"), false},
+		{nil, false},
+	} {
+		if got := rejected(tt.err); got != tt.want {
+			t.Errorf("rejected(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPostToSinkDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // doesn't answer in time
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := postToSink(ctx, SinkConfig{Name: "slow", URL: srv.URL}, []byte("{}"), "application/json"); err == nil {
+		t.Fatal("expected the deadline to cut the request short")
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("waited %s past the deadline", waited)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,74 +18,288 @@ type Game struct {
 	Process    string `json:"process,omitempty"`
 }
 
+// Session is a finished play session. Times are in UTC; the durations are
+// measured on the monotonic clock, so they hold across clock changes.
 type Session struct {
 	Game      Game      `json:"game"`
 	StartedAt time.Time `json:"started_at"`
 	EndedAt   time.Time `json:"ended_at"`
 	Duration  float64   `json:"duration_seconds"`
+	Focused   float64   `json:"focused_seconds,omitempty"`
+	Profile   string    `json:"profile,omitempty"` // who was playing, see Config.Profiles
+	// ClockAdjusted is set when the system clock jumped during the session
+	// and its times were corrected for it.
+	ClockAdjusted bool `json:"clock_adjusted,omitempty"`
 }
 
 type activeSession struct {
-	game      Game
-	startedAt time.Time
+	game          Game
+	profile       string
+	startedAt     time.Time
+	resumedAt     time.Time     // start of the current segment after a merge
+	played        time.Duration // play time of earlier merged segments
+	focusedSince  time.Time     // zero while the game is in the background
+	focused       time.Duration // focus time accumulated before focusedSince
+	clockAdjusted bool
+}
+
+// SessionPolicy controls how finished sessions are stitched together and filtered.
+type SessionPolicy struct {
+	// MergeGap joins a session onto the previous one of the same game when it
+	// starts less than MergeGap after that one ended.
+	MergeGap time.Duration
+	// MinDuration drops sessions shorter than this as noise.
+	MinDuration time.Duration
+	// GameMinDuration overrides MinDuration, keyed by lower-case game name.
+	GameMinDuration map[string]time.Duration
+}
+
+func (p SessionPolicy) minDuration(g Game) time.Duration {
+	if d, ok := p.GameMinDuration[strings.ToLower(g.Name)]; ok {
+		return d
+	}
+	return p.MinDuration
 }
 
 type SessionBuffer struct {
 	mu      sync.Mutex
 	pending []Session
 	active  *activeSession
+	last    *Session  // finished, but may still be merged with a relaunch
+	recent  []Session // last recorded sessions, oldest first
+	policy  SessionPolicy
+	profile string // profile new sessions are recorded for
 	path    string
+	clock   Clock
 }
 
-func newSessionBuffer() *SessionBuffer {
+func newSessionBuffer(policy SessionPolicy, clock Clock) *SessionBuffer {
 	dir := dataDir()
 	_ = os.MkdirAll(dir, 0755)
 	buf := &SessionBuffer{
-		path: filepath.Join(dir, "buffer.json"),
+		policy: policy,
+		path:   filepath.Join(dir, "buffer.json"),
+		clock:  clock,
 	}
 	buf.load()
 	return buf
 }
 
+// SetPolicy replaces the stitching and filtering rules, e.g. after a config reload.
+func (b *SessionBuffer) SetPolicy(p SessionPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.policy = p
+}
+
 func (b *SessionBuffer) StartGame(g Game) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	now := b.clock.Now()
 	if b.active != nil {
-		b.finishActive()
+		b.finishActive(now)
+	}
+
+	// Relaunch of the game that just ended: continue its session
+	if last := b.last; last != nil && last.Game.Name == g.Name && last.Profile == b.profile && now.Sub(last.EndedAt) < b.policy.MergeGap {
+		b.last = nil
+		b.active = &activeSession{
+			game:          last.Game,
+			profile:       last.Profile,
+			startedAt:     last.StartedAt,
+			resumedAt:     now,
+			played:        time.Duration(last.Duration * float64(time.Second)),
+			focused:       time.Duration(last.Focused * float64(time.Second)),
+			clockAdjusted: last.ClockAdjusted,
+		}
+		log.Printf("Session resumed: %s", g.Name)
+		return
+	}
+
+	if b.settle(now, true) {
+		b.save()
+	}
+	b.active = &activeSession{game: g, profile: b.profile, startedAt: now, resumedAt: now}
+}
+
+// SetProfile records sessions for profile from now on. A running session is
+// split, so each part is attributed to whoever played it.
+func (b *SessionBuffer) SetProfile(profile string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if profile == b.profile {
+		return
+	}
+	b.profile = profile
+	if a := b.active; a != nil {
+		now := b.clock.Now()
+		focused := !a.focusedSince.IsZero()
+		b.finishActive(now)
+		if b.settle(now, true) {
+			b.save()
+		}
+		b.active = &activeSession{game: a.game, profile: profile, startedAt: now, resumedAt: now}
+		if focused {
+			b.active.focusedSince = now
+		}
 	}
-	b.active = &activeSession{game: g, startedAt: time.Now()}
 }
 
 func (b *SessionBuffer) EndGame() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if b.active != nil {
-		b.finishActive()
+		b.finishActive(b.clock.Now())
+	}
+}
+
+// EndGameAt ends the active game as of at, a moment already passed, e.g. the
+// last detection before the machine went to sleep.
+func (b *SessionBuffer) EndGameAt(at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.active
+	if a == nil {
+		return
+	}
+	if at.Before(a.resumedAt) {
+		at = a.resumedAt
+	}
+	if !a.focusedSince.IsZero() && at.Before(a.focusedSince) {
+		a.focusedSince = at
+	}
+	b.finishActive(at)
+}
+
+// Flush ends the active game and records it without waiting for a relaunch.
+func (b *SessionBuffer) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if b.active != nil {
+		b.finishActive(now)
+	}
+	if b.settle(now, true) {
+		b.save()
+	}
+}
+
+// SetFocused records whether the active game currently owns the foreground window.
+func (b *SessionBuffer) SetFocused(focused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.active == nil {
+		return
+	}
+	a := b.active
+	now := b.clock.Now()
+	switch {
+	case focused && a.focusedSince.IsZero():
+		a.focusedSince = now
+	case !focused && !a.focusedSince.IsZero():
+		a.focused += now.Sub(a.focusedSince)
+		a.focusedSince = time.Time{}
+	}
+}
+
+// ClockJumped moves the times of the running and the last finished session by
+// delta, after the system clock was set forward (or back) by that much, so
+// they read in the new clock. Their durations stay the same.
+func (b *SessionBuffer) ClockJumped(delta time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Shifted times lose their monotonic reading, so later durations are
+	// measured on the corrected wall clock
+	shift := func(t *time.Time) {
+		if !t.IsZero() {
+			*t = t.Round(0).Add(delta)
+		}
+	}
+	if a := b.active; a != nil {
+		shift(&a.startedAt)
+		shift(&a.resumedAt)
+		shift(&a.focusedSince)
+		a.clockAdjusted = true
+	}
+	if l := b.last; l != nil {
+		shift(&l.StartedAt)
+		shift(&l.EndedAt)
+		l.ClockAdjusted = true
+		b.save()
 	}
 }
 
 // finishActive must be called with b.mu held.
-func (b *SessionBuffer) finishActive() {
-	now := time.Now()
-	s := Session{
-		Game:      b.active.game,
-		StartedAt: b.active.startedAt,
-		EndedAt:   now,
-		Duration:  now.Sub(b.active.startedAt).Seconds(),
+func (b *SessionBuffer) finishActive(now time.Time) {
+	a := b.active
+	focused := a.focused
+	if !a.focusedSince.IsZero() {
+		focused += now.Sub(a.focusedSince)
 	}
 	b.active = nil
-	// Filter out noise: sessions under 10 seconds
-	if s.Duration < 10 {
-		return
+	b.last = &Session{
+		Game:          a.game,
+		StartedAt:     a.startedAt.UTC(),
+		EndedAt:       now.UTC(),
+		Duration:      (a.played + now.Sub(a.resumedAt)).Seconds(),
+		Focused:       focused.Seconds(),
+		Profile:       a.profile,
+		ClockAdjusted: a.clockAdjusted,
 	}
-	b.pending = append(b.pending, s)
+	b.settle(now, false)
 	b.save()
+}
+
+// settle moves the last finished session to pending once the merge gap has
+// passed, or immediately when force is set. Sessions shorter than the minimum
+// duration are dropped. Must be called with b.mu held; reports whether the
+// buffer changed.
+func (b *SessionBuffer) settle(now time.Time, force bool) bool {
+	if b.last == nil {
+		return false
+	}
+	if !force && now.Sub(b.last.EndedAt) < b.policy.MergeGap {
+		return false
+	}
+	s := *b.last
+	b.last = nil
+	// Filter out noise: sessions under the minimum duration
+	if s.Duration < b.policy.minDuration(s.Game).Seconds() {
+		return true
+	}
+	b.pending = append(b.pending, s)
+	b.recent = append(b.recent, s)
+	if len(b.recent) > maxRecentSessions {
+		b.recent = b.recent[len(b.recent)-maxRecentSessions:]
+	}
 	log.Printf("Session recorded: %s (%.0fs)", s.Game.Name, s.Duration)
+	return true
+}
+
+const maxRecentSessions = 10
+
+// Recent returns the last sessions recorded since startup, newest first.
+// It only reads: a finished session that is due to settle is listed but left
+// for the next write to record.
+func (b *SessionBuffer) Recent() []Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := slices.Clone(b.recent)
+	if l := b.last; l != nil && b.clock.Now().Sub(l.EndedAt) >= b.policy.MergeGap &&
+		l.Duration >= b.policy.minDuration(l.Game).Seconds() {
+		out = append(out, *l)
+		out = out[max(len(out)-maxRecentSessions, 0):]
+	}
+	slices.Reverse(out)
+	return out
 }
 
 func (b *SessionBuffer) Drain() []Session {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if b.settle(b.clock.Now(), false) {
+		b.save()
+	}
 	if len(b.pending) == 0 {
 		return nil
 	}
@@ -97,19 +313,20 @@ func (b *SessionBuffer) Drain() []Session {
 func (b *SessionBuffer) HasPending() bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if b.settle(b.clock.Now(), false) {
+		b.save()
+	}
 	return len(b.pending) > 0
 }
 
-// Restore puts sessions back if reporting failed.
-func (b *SessionBuffer) Restore(sessions []Session) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.pending = append(sessions, b.pending...)
-	b.save()
-}
-
+// save writes pending sessions to disk, including the last finished one so it
+// survives a crash while waiting to be merged.
 func (b *SessionBuffer) save() {
-	data, err := json.MarshalIndent(b.pending, "", "  ")
+	sessions := b.pending
+	if b.last != nil {
+		sessions = append(sessions[:len(sessions):len(sessions)], *b.last)
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
 	if err != nil {
 		log.Printf("buffer save: %v", err)
 		return
@@ -128,10 +345,17 @@ func (b *SessionBuffer) load() {
 		log.Printf("buffer load: %v", err)
 		return
 	}
-	if err := json.Unmarshal(data, &b.pending); err != nil {
+	var sessions []Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
 		log.Printf("buffer parse: %v", err)
 		return
 	}
+	// The last session is saved before settle has checked its length
+	for _, s := range sessions {
+		if s.Duration >= b.policy.minDuration(s.Game).Seconds() {
+			b.pending = append(b.pending, s)
+		}
+	}
 	if len(b.pending) > 0 {
 		log.Printf("Loaded %d unsent sessions from disk", len(b.pending))
 	}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// step starts game at offset at, or ends the active game when game is empty.
+type step struct {
+	at   time.Duration
+	game string
+}
+
+type wantSession struct {
+	game     string
+	start    time.Duration
+	end      time.Duration
+	duration time.Duration
+}
+
+func newTestBuffer(t *testing.T, policy SessionPolicy, clock Clock) *SessionBuffer {
+	t.Helper()
+	return &SessionBuffer{
+		policy: policy,
+		path:   filepath.Join(t.TempDir(), "buffer.json"),
+		clock:  clock,
+	}
+}
+
+func TestSessionStitching(t *testing.T) {
+	base := SessionPolicy{MergeGap: 2 * time.Minute, MinDuration: 10 * time.Second}
+
+	tests := []struct {
+		name    string
+		policy  SessionPolicy
+		steps   []step
+		drainAt time.Duration
+		want    []wantSession
+	}{
+		{
+			name:    "single session",
+			policy:  base,
+			steps:   []step{{0, "Factorio"}, {30 * time.Minute, ""}},
+			drainAt: 40 * time.Minute,
+			want:    []wantSession{{"Factorio", 0, 30 * time.Minute, 30 * time.Minute}},
+		},
+		{
+			name:    "short session dropped",
+			policy:  base,
+			steps:   []step{{0, "Factorio"}, {5 * time.Second, ""}},
+			drainAt: 10 * time.Minute,
+			want:    nil,
+		},
+		{
+			name:   "relaunch within gap is merged",
+			policy: base,
+			steps: []step{
+				{0, "Factorio"}, {10 * time.Minute, ""},
+				{11 * time.Minute, "Factorio"}, {20 * time.Minute, ""},
+			},
+			drainAt: 30 * time.Minute,
+			want:    []wantSession{{"Factorio", 0, 20 * time.Minute, 19 * time.Minute}},
+		},
+		{
+			name:   "relaunch after gap is a new session",
+			policy: base,
+			steps: []step{
+				{0, "Factorio"}, {10 * time.Minute, ""},
+				{15 * time.Minute, "Factorio"}, {20 * time.Minute, ""},
+			},
+			drainAt: 30 * time.Minute,
+			want: []wantSession{
+				{"Factorio", 0, 10 * time.Minute, 10 * time.Minute},
+				{"Factorio", 15 * time.Minute, 20 * time.Minute, 5 * time.Minute},
+			},
+		},
+		{
+			name:   "other game in between is not merged",
+			policy: base,
+			steps: []step{
+				{0, "Factorio"}, {10 * time.Minute, "RimWorld"},
+				{11 * time.Minute, "Factorio"}, {20 * time.Minute, ""},
+			},
+			drainAt: 30 * time.Minute,
+			want: []wantSession{
+				{"Factorio", 0, 10 * time.Minute, 10 * time.Minute},
+				{"RimWorld", 10 * time.Minute, 11 * time.Minute, time.Minute},
+				{"Factorio", 11 * time.Minute, 20 * time.Minute, 9 * time.Minute},
+			},
+		},
+		{
+			name:   "crash loop fragments add up past the minimum",
+			policy: base,
+			steps: []step{
+				{0, "Factorio"}, {4 * time.Second, ""},
+				{30 * time.Second, "Factorio"}, {34 * time.Second, ""},
+				{time.Minute, "Factorio"}, {64 * time.Second, ""},
+			},
+			drainAt: 10 * time.Minute,
+			want:    []wantSession{{"Factorio", 0, 64 * time.Second, 12 * time.Second}},
+		},
+		{
+			name:    "no gap disables merging",
+			policy:  SessionPolicy{MinDuration: 10 * time.Second},
+			steps:   []step{{0, "Factorio"}, {time.Minute, ""}, {time.Minute, "Factorio"}, {2 * time.Minute, ""}},
+			drainAt: 2 * time.Minute,
+			want: []wantSession{
+				{"Factorio", 0, time.Minute, time.Minute},
+				{"Factorio", time.Minute, 2 * time.Minute, time.Minute},
+			},
+		},
+		{
+			name: "per-game minimum keeps short session",
+			policy: SessionPolicy{
+				MinDuration:     time.Minute,
+				GameMinDuration: map[string]time.Duration{"factorio": 0},
+			},
+			steps:   []step{{0, "Factorio"}, {5 * time.Second, "RimWorld"}, {10 * time.Second, ""}},
+			drainAt: time.Minute,
+			want:    []wantSession{{"Factorio", 0, 5 * time.Second, 5 * time.Second}},
+		},
+		{
+			name: "per-game minimum drops long session",
+			policy: SessionPolicy{
+				MinDuration:     10 * time.Second,
+				GameMinDuration: map[string]time.Duration{"rimworld": 2 * time.Minute},
+			},
+			steps:   []step{{0, "Factorio"}, {time.Minute, "RimWorld"}, {2 * time.Minute, ""}},
+			drainAt: 10 * time.Minute,
+			want:    []wantSession{{"Factorio", 0, time.Minute, time.Minute}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			b := newTestBuffer(t, tt.policy, clock)
+			for _, s := range tt.steps {
+				clock.Set(s.at)
+				if s.game == "" {
+					b.EndGame()
+				} else {
+					b.StartGame(Game{Name: s.game, Source: "config"})
+				}
+			}
+			clock.Set(tt.drainAt)
+			assertSessions(t, b.Drain(), tt.want)
+		})
+	}
+}
+
+func TestSessionHeldUntilGapPasses(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: 2 * time.Minute}, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.EndGame()
+
+	clock.Advance(time.Minute)
+	if b.HasPending() {
+		t.Fatal("session pending before merge gap passed")
+	}
+	clock.Advance(time.Minute)
+	if !b.HasPending() {
+		t.Fatal("session not pending after merge gap passed")
+	}
+}
+
+func TestSessionFlushSkipsGap(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: time.Hour}, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.Flush()
+
+	assertSessions(t, b.Drain(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+}
+
+func TestSessionWaitingForMergeSurvivesRestart(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: time.Hour}, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.EndGame()
+
+	reloaded := &SessionBuffer{path: b.path, clock: clock}
+	reloaded.load()
+	assertSessions(t, reloaded.Drain(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+}
+
+func TestSessionTooShortDroppedAfterRestart(t *testing.T) {
+	clock := newFakeClock()
+	policy := SessionPolicy{MergeGap: time.Hour, MinDuration: 10 * time.Second}
+	b := newTestBuffer(t, policy, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(3 * time.Second)
+	b.EndGame()
+
+	reloaded := &SessionBuffer{policy: policy, path: b.path, clock: clock}
+	reloaded.load()
+	assertSessions(t, reloaded.Drain(), nil)
+}
+
+func TestSessionRecentSurvivesDrain(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{}, clock)
+
+	for _, name := range []string{"Factorio", "RimWorld"} {
+		b.StartGame(Game{Name: name})
+		clock.Advance(10 * time.Minute)
+		b.EndGame()
+	}
+	b.Drain()
+
+	assertSessions(t, b.Recent(), []wantSession{
+		{"RimWorld", 10 * time.Minute, 20 * time.Minute, 10 * time.Minute},
+		{"Factorio", 0, 10 * time.Minute, 10 * time.Minute},
+	})
+}
+
+func TestSessionRecentIsReadOnly(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: 5 * time.Minute}, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.EndGame()
+	clock.Advance(6 * time.Minute)
+
+	assertSessions(t, b.Recent(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+	if b.last == nil || len(b.pending) != 0 || len(b.recent) != 0 {
+		t.Errorf("Recent settled the buffer: last %v, %d pending, %d recent", b.last, len(b.pending), len(b.recent))
+	}
+	assertSessions(t, b.Drain(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+	assertSessions(t, b.Recent(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+}
+
+func TestSessionProfileSwitch(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: 5 * time.Minute}, clock)
+	b.SetProfile("Anna")
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.SetProfile("Ben")
+	clock.Advance(20 * time.Minute)
+	b.EndGame()
+	// A relaunch by someone else is a new session, not a continuation
+	b.SetProfile("Anna")
+	clock.Advance(time.Minute)
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(5 * time.Minute)
+	b.Flush()
+
+	got := b.Drain()
+	assertSessions(t, got, []wantSession{
+		{"Factorio", 0, 10 * time.Minute, 10 * time.Minute},
+		{"Factorio", 10 * time.Minute, 30 * time.Minute, 20 * time.Minute},
+		{"Factorio", 31 * time.Minute, 36 * time.Minute, 5 * time.Minute},
+	})
+	for i, want := range []string{"Anna", "Ben", "Anna"} {
+		if got[i].Profile != want {
+			t.Errorf("session %d profile %q, want %q", i, got[i].Profile, want)
+		}
+	}
+}
+
+func TestSessionClockJumped(t *testing.T) {
+	clock := newFakeClock()
+	b := newTestBuffer(t, SessionPolicy{MergeGap: 5 * time.Minute}, clock)
+
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	b.EndGame()
+	// Clock set an hour forward while waiting for a relaunch
+	clock.Advance(time.Hour)
+	b.ClockJumped(time.Hour)
+	clock.Advance(time.Minute)
+	b.StartGame(Game{Name: "Factorio"})
+	clock.Advance(10 * time.Minute)
+	// and half an hour back while playing
+	clock.Advance(-30 * time.Minute)
+	b.ClockJumped(-30 * time.Minute)
+	clock.Advance(5 * time.Minute)
+	b.Flush()
+	b.StartGame(Game{Name: "RimWorld"})
+	clock.Advance(5 * time.Minute)
+	b.Flush()
+
+	got := b.Drain()
+	assertSessions(t, got, []wantSession{
+		{"Factorio", 30 * time.Minute, 56 * time.Minute, 25 * time.Minute},
+		{"RimWorld", 56 * time.Minute, 61 * time.Minute, 5 * time.Minute},
+	})
+	if !got[0].ClockAdjusted || got[1].ClockAdjusted {
+		t.Errorf("clock_adjusted %v, %v; want true, false", got[0].ClockAdjusted, got[1].ClockAdjusted)
+	}
+	if got[0].StartedAt.Location() != time.UTC {
+		t.Errorf("started_at in %v, want UTC", got[0].StartedAt.Location())
+	}
+}
+
+func assertSessions(t *testing.T, got []Session, want []wantSession) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d sessions, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Game.Name != w.game {
+			t.Errorf("session %d: game %q, want %q", i, g.Game.Name, w.game)
+		}
+		if !g.StartedAt.Equal(testEpoch.Add(w.start)) {
+			t.Errorf("session %d: started at %v, want %v", i, g.StartedAt, testEpoch.Add(w.start))
+		}
+		if !g.EndedAt.Equal(testEpoch.Add(w.end)) {
+			t.Errorf("session %d: ended at %v, want %v", i, g.EndedAt, testEpoch.Add(w.end))
+		}
+		if g.Duration != w.duration.Seconds() {
+			t.Errorf("session %d: duration %.0fs, want %.0fs", i, g.Duration, w.duration.Seconds())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// steamWrappers are processes in a Steam game's tree that aren't the game
+// itself: launchers, container tooling, shells and Wine services.
+var steamWrappers = map[string]bool{
+	"reaper":                   true,
+	"steam":                    true,
+	"steamwebhelper":           true,
+	"steam-launch-wrapper":     true,
+	"pressure-vessel-wrap":     true,
+	"pressure-vessel-adverb":   true,
+	"pressure-vessel-launcher": true,
+	"pv-bwrap":                 true,
+	"srt-bwrap":                true,
+	"bwrap":                    true,
+	"proton":                   true,
+	"python3":                  true,
+	"sh":                       true,
+	"bash":                     true,
+	"wine":                     true,
+	"wine64":                   true,
+	"wineserver":               true,
+	"wineboot":                 true,
+	"services":                 true,
+	"winedevice":               true,
+	"explorer":                 true,
+	"plugplay":                 true,
+	"svchost":                  true,
+	"rpcss":                    true,
+	"tabtip":                   true,
+	"conhost":                  true,
+	"start":                    true,
+	"rundll32":                 true,
+}
+
+// isSteamWrapper reports whether a process is part of Steam's launch
+// machinery rather than the game. It goes by what actually runs, comm or the
+// executable, since wrappers carry the game's path in their arguments. Only
+// processes of Wine's loader are judged by the Windows program they run.
+func isSteamWrapper(comm, exeName, name string) bool {
+	if isWineLoader(comm, exeName) {
+		return steamWrappers[strings.ToLower(name)]
+	}
+	return steamWrappers[strings.ToLower(comm)] || steamWrappers[strings.ToLower(exeName)]
+}
+
+// steamCandidate is a process carrying a SteamAppId.
+type steamCandidate struct {
+	pid       int
+	ppid      int
+	appID     int
+	name      string
+	wrapper   bool  // see isSteamWrapper
+	rss       int64 // resident set size in pages
+	hasWindow bool
+}
+
+// pickSteamGame chooses the game's own process among everything carrying a
+// SteamAppId: one that owns a window, then anything that isn't a known wrapper,
+// then the largest resident memory, then the deepest in the process tree.
+// The returned PIDs cover every candidate of the chosen app ID.
+func pickSteamGame(cands []steamCandidate) (SteamApp, bool) {
+	if len(cands) == 0 {
+		return SteamApp{}, false
+	}
+
+	byPID := make(map[int]*steamCandidate, len(cands))
+	for i := range cands {
+		byPID[cands[i].pid] = &cands[i]
+	}
+	depth := make(map[int]int, len(cands))
+	for _, c := range cands {
+		// Bounded by the candidate count in case of a ppid cycle
+		for p := byPID[c.ppid]; p != nil && depth[c.pid] < len(cands); p = byPID[p.ppid] {
+			depth[c.pid]++
+		}
+	}
+
+	best := slices.MaxFunc(cands, func(a, b steamCandidate) int {
+		if a.hasWindow != b.hasWindow {
+			return boolCmp(a.hasWindow, b.hasWindow)
+		}
+		if a.wrapper != b.wrapper {
+			return boolCmp(b.wrapper, a.wrapper)
+		}
+		if a.rss != b.rss {
+			return cmp.Compare(a.rss, b.rss)
+		}
+		if depth[a.pid] != depth[b.pid] {
+			return cmp.Compare(depth[a.pid], depth[b.pid])
+		}
+		// Lowest PID wins ties, so the result doesn't depend on map order
+		return cmp.Compare(b.pid, a.pid)
+	})
+
+	var others []int
+	for _, c := range cands {
+		if c.appID == best.appID && c.pid != best.pid {
+			others = append(others, c.pid)
+		}
+	}
+	slices.Sort(others)
+
+	return SteamApp{
+		AppID:   best.appID,
+		Process: best.name,
+		PIDs:    append([]int{best.pid}, others...),
+	}, true
+}
+
+func boolCmp(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// readStat returns the parent PID and resident set size (in pages) of pid.
+func readStat(pid int) (int, int64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// comm is in parentheses and may contain spaces; fields follow the last ')'
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("malformed stat for %d", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("malformed stat for %d", pid)
+	}
+	ppid, _ := strconv.Atoi(fields[1])             // field 4
+	rss, _ := strconv.ParseInt(fields[21], 10, 64) // field 24
+	return ppid, rss, nil
+}
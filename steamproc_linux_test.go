@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPickSteamGame(t *testing.T) {
+	// A typical Proton launch: reaper -> pressure-vessel -> proton -> wine processes
+	proton := []steamCandidate{
+		{pid: 100, ppid: 1, appID: 1091500, name: "reaper", wrapper: true, rss: 500},
+		{pid: 101, ppid: 100, appID: 1091500, name: "pv-bwrap", wrapper: true, rss: 800},
+		{pid: 102, ppid: 101, appID: 1091500, name: "proton", wrapper: true, rss: 4000},
+		{pid: 103, ppid: 102, appID: 1091500, name: "wineserver", wrapper: true, rss: 3000},
+		{pid: 104, ppid: 102, appID: 1091500, name: "Cyberpunk2077", rss: 900000},
+		{pid: 105, ppid: 102, appID: 1091500, name: "services", wrapper: true, rss: 2000},
+	}
+
+	tests := []struct {
+		name        string
+		cands       []steamCandidate
+		wantProcess string
+		wantPIDs    []int
+	}{
+		{
+			name:        "largest process under proton",
+			cands:       proton,
+			wantProcess: "Cyberpunk2077",
+			wantPIDs:    []int{104, 100, 101, 102, 103, 105},
+		},
+		{
+			name: "window owner beats memory",
+			cands: []steamCandidate{
+				{pid: 10, ppid: 1, appID: 730, name: "cs2", rss: 1000, hasWindow: true},
+				{pid: 11, ppid: 10, appID: 730, name: "shadercache", rss: 5000},
+			},
+			wantProcess: "cs2",
+			wantPIDs:    []int{10, 11},
+		},
+		{
+			name: "wrapper loses even when larger",
+			cands: []steamCandidate{
+				{pid: 20, ppid: 1, appID: 440, name: "bash", wrapper: true, rss: 9000},
+				{pid: 21, ppid: 20, appID: 440, name: "hl2_linux", rss: 100},
+			},
+			wantProcess: "hl2_linux",
+			wantPIDs:    []int{21, 20},
+		},
+		{
+			name: "deepest child on equal memory",
+			cands: []steamCandidate{
+				{pid: 30, ppid: 1, appID: 570, name: "dota2", rss: 100},
+				{pid: 31, ppid: 30, appID: 570, name: "dota2", rss: 100},
+			},
+			wantProcess: "dota2",
+			wantPIDs:    []int{31, 30},
+		},
+		{
+			name: "only pids of the chosen app",
+			cands: []steamCandidate{
+				{pid: 40, ppid: 1, appID: 730, name: "cs2", rss: 100},
+				{pid: 41, ppid: 1, appID: 440, name: "hl2_linux", rss: 50},
+			},
+			wantProcess: "cs2",
+			wantPIDs:    []int{40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ok := pickSteamGame(tt.cands)
+			if !ok {
+				t.Fatal("no game picked")
+			}
+			if app.Process != tt.wantProcess {
+				t.Errorf("process %q, want %q", app.Process, tt.wantProcess)
+			}
+			if !slices.Equal(app.PIDs, tt.wantPIDs) {
+				t.Errorf("pids %v, want %v", app.PIDs, tt.wantPIDs)
+			}
+		})
+	}
+
+	if _, ok := pickSteamGame(nil); ok {
+		t.Error("picked a game from no candidates")
+	}
+}
+
+func TestIsSteamWrapper(t *testing.T) {
+	const game = `/home/me/.steam/steam/steamapps/common/Cyberpunk 2077/bin/x64/Cyberpunk2077.exe`
+	tests := []struct {
+		name    string
+		comm    string
+		exe     string
+		cmdline []string
+		want    bool
+	}{
+		{"reaper", "reaper", "/home/me/.steam/steam/ubuntu12_32/reaper",
+			[]string{"reaper", "SteamLaunch", "AppId=1091500", "--", game}, true},
+		{"proton script", "python3", "/usr/bin/python3.11",
+			[]string{"python3", "/home/me/.steam/steam/steamapps/common/Proton 9.0/proton", "waitforexitandrun", game}, true},
+		{"pressure-vessel, comm truncated", "pressure-vessel", "/usr/lib/pressure-vessel/pressure-vessel-wrap",
+			[]string{"pressure-vessel-wrap", "--", game}, true},
+		{"game under proton", "Cyberpunk2077.e", "/home/me/.steam/steam/steamapps/common/Proton 9.0/files/bin/wine64-preloader",
+			[]string{game}, false},
+		{"wine service", "services.exe", "/home/me/.steam/steam/steamapps/common/Proton 9.0/files/bin/wine64-preloader",
+			[]string{`C:\windows\system32\services.exe`}, true},
+		{"native game", "hl2_linux", "/home/me/.steam/steam/steamapps/common/Half-Life 2/hl2_linux",
+			[]string{"hl2_linux", "-game", "hl2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := resolveExeName(tt.comm, tt.exe, tt.cmdline)
+			if got := isSteamWrapper(tt.comm, filepath.Base(tt.exe), name); got != tt.want {
+				t.Errorf("isSteamWrapper(%q, %q, %q) = %v, want %v", tt.comm, filepath.Base(tt.exe), name, got, tt.want)
+			}
+		})
+	}
+}
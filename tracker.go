@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Tracker turns successive detections into session starts, stops and switches.
+type Tracker struct {
+	detector *Detector
+	buf      *SessionBuffer
+	current  *DetectedGame
+	// onChange is called when the played game changes, with nil when
+	// nothing is playing anymore.
+	onChange func(*DetectedGame)
+	paused   atomic.Bool
+	// lastWall and lastUp are the wall clock and uptime at the previous
+	// clock check.
+	lastWall time.Time
+	lastUp   time.Duration
+}
+
+// sleepGap is how long detection may stall before the machine is taken to
+// have been asleep, for sleeps the OS didn't announce.
+const sleepGap = time.Minute
+
+// maxClockJump is how far the wall clock may drift from the time actually
+// passed between detections before it counts as set.
+const maxClockJump = 10 * time.Second
+
+func newTracker(detector *Detector, buf *SessionBuffer, onChange func(*DetectedGame)) *Tracker {
+	return &Tracker{detector: detector, buf: buf, onChange: onChange}
+}
+
+// Tick runs one detection and updates the session buffer accordingly.
+func (t *Tracker) Tick(cfg *Config) {
+	var current string
+	if t.current != nil {
+		current = t.current.Name
+	}
+	detected := t.detector.Detect(cfg, current)
+	if t.paused.Load() || (detected != nil && cfg.Privacy.excluded(detected.Name)) {
+		detected = nil
+	}
+
+	if detected == nil {
+		if t.current != nil {
+			log.Printf("Game ended: %s", t.current.Name)
+			t.buf.EndGame()
+			t.current = nil
+			t.notify(nil)
+		}
+		return
+	}
+
+	if t.current == nil || t.current.Name != detected.Name {
+		if t.current != nil {
+			t.buf.EndGame()
+		}
+		t.current = detected
+		t.buf.StartGame(detected.game())
+		t.notify(detected)
+		log.Printf("Game started: %s (%s)", detected.Name, detected.Source)
+	}
+	t.buf.SetFocused(detected.Focused)
+}
+
+// Suspend ends the running session as of at, when the machine went to sleep
+// or is shutting down. Detection picks the game up again on the next tick.
+func (t *Tracker) Suspend(at time.Time) {
+	if t.current == nil {
+		return
+	}
+	log.Printf("Game interrupted: %s", t.current.Name)
+	t.buf.EndGameAt(at)
+	t.current = nil
+	t.notify(nil)
+}
+
+// SyncClock makes now and up, the time since boot, the reference for the
+// next CheckClock without checking them, e.g. after an announced sleep.
+func (t *Tracker) SyncClock(now time.Time, up time.Duration) {
+	t.lastWall, t.lastUp = now.Round(0), up
+}
+
+// CheckClock compares how far the wall clock moved since the last check with
+// the time that actually passed. A larger difference means the clock was set,
+// and session times are moved along with it; time passed beyond sleepGap
+// means the machine slept, and the running session ends where it stalled.
+func (t *Tracker) CheckClock(now time.Time, up time.Duration) {
+	now = now.Round(0)
+	if t.lastWall.IsZero() {
+		t.SyncClock(now, up)
+		return
+	}
+	last, elapsed := t.lastWall, up-t.lastUp
+	if jump := now.Sub(last) - elapsed; jump > maxClockJump || jump < -maxClockJump {
+		log.Printf("System clock jumped by %s, correcting session times", jump.Round(time.Second))
+		t.buf.ClockJumped(jump)
+		last = last.Add(jump)
+	}
+	if elapsed > sleepGap {
+		log.Printf("No detection for %s, assuming the machine slept", elapsed.Round(time.Second))
+		t.Suspend(last)
+	}
+	t.SyncClock(now, up)
+}
+
+// SetPaused stops tracking until it is unpaused; the running session ends
+// on the next tick.
+func (t *Tracker) SetPaused(paused bool) {
+	t.paused.Store(paused)
+}
+
+func (t *Tracker) notify(g *DetectedGame) {
+	if t.onChange != nil {
+		t.onChange(g)
+	}
+}
@@ -6,98 +6,256 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 )
 
-// getSteamRunningApp scans /proc for a process owned by the current user
-// that has SteamAppId set in its environment.
-func getSteamRunningApp() (int, string, error) {
+// procInfo is what the tracker needs to know about one of the user's processes.
+type procInfo struct {
+	comm    string
+	name    string // full executable name, resolved through Wine where needed
+	wrapper bool   // part of Steam's launch machinery, see isSteamWrapper
+	appID   int    // SteamAppId from the environment, 0 if unset
+}
+
+// getSteamRunningApp looks for processes owned by the current user that have
+// SteamAppId set in their environment, and picks the game among them.
+func getSteamRunningApp() (SteamApp, error) {
+	procs, err := processSnapshot()
+	if err != nil {
+		return SteamApp{}, err
+	}
+
+	var cands []steamCandidate
+	var windows map[int]bool
+	for pid, p := range procs {
+		if p.appID <= 0 {
+			continue
+		}
+		if windows == nil {
+			windows = getWindowPIDs()
+		}
+		// Memory and parent change over a process' life, so read them fresh
+		ppid, rss, err := readStat(pid)
+		if err != nil {
+			continue
+		}
+		cands = append(cands, steamCandidate{
+			pid:       pid,
+			ppid:      ppid,
+			appID:     p.appID,
+			name:      p.name,
+			wrapper:   p.wrapper,
+			rss:       rss,
+			hasWindow: windows[pid],
+		})
+	}
+
+	app, ok := pickSteamGame(cands)
+	if !ok {
+		return SteamApp{}, fmt.Errorf("no steam game running")
+	}
+	return app, nil
+}
+
+// getRunningProcesses returns the names of all processes owned by the current user.
+func getRunningProcesses() ([]string, error) {
+	procs, err := processSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(procs))
+	for _, p := range procs {
+		if p.name != "" {
+			names = append(names, p.name)
+		}
+	}
+	return names, nil
+}
+
+// processSnapshot returns the user's processes, from the table kept current by
+// process events when available, otherwise by scanning /proc.
+func processSnapshot() (map[int]procInfo, error) {
+	if w := procWatcher(); w != nil && w.alive() {
+		return w.snapshot(), nil
+	}
+	return scanProcesses()
+}
+
+// scanProcesses inspects every process in /proc.
+func scanProcesses() (map[int]procInfo, error) {
 	entries, err := os.ReadDir("/proc")
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 
+	procs := make(map[int]procInfo)
 	for _, e := range entries {
 		pid, err := strconv.Atoi(e.Name())
 		if err != nil || pid <= 0 {
 			continue
 		}
-
-		envPath := fmt.Sprintf("/proc/%d/environ", pid)
-		appID, err := readSteamAppID(envPath)
-		if err != nil || appID == 0 {
-			continue
+		if p, ok := inspectProcess(pid); ok {
+			procs[pid] = p
 		}
+	}
+	return procs, nil
+}
+
+// inspectProcess reads a single process. Processes of other users (or ones
+// that already exited) are reported as not ok.
+func inspectProcess(pid int) (procInfo, bool) {
+	info, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	if err != nil {
+		return procInfo{}, false
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); !ok || st.Uid != uint32(os.Getuid()) {
+		return procInfo{}, false
+	}
 
-		commPath := fmt.Sprintf("/proc/%d/comm", pid)
-		comm, _ := os.ReadFile(commPath)
-		process := strings.TrimSpace(string(comm))
+	comm, err := procComm(pid)
+	if err != nil {
+		return procInfo{}, false
+	}
+	env, _ := readEnviron(pid)
+	name, wrapper := describeProcess(pid, comm)
+	return procInfo{
+		comm:    comm,
+		name:    name,
+		wrapper: wrapper,
+		appID:   steamAppID(env),
+	}, true
+}
+
+// processName resolves the full executable name of pid.
+func processName(pid int, comm string) string {
+	name, _ := describeProcess(pid, comm)
+	return name
+}
 
-		return appID, process, nil
+// describeProcess resolves the full executable name of pid and whether it is
+// a Steam launch wrapper.
+func describeProcess(pid int, comm string) (string, bool) {
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	exe = strings.TrimSuffix(exe, " (deleted)")
+	var exeName string
+	if exe != "" {
+		exeName = filepath.Base(exe)
 	}
+	name := resolveExeName(comm, exe, readCmdline(pid))
+	return name, isSteamWrapper(comm, exeName, name)
+}
 
-	return 0, "", fmt.Errorf("no steam game running")
+// steamAppID returns the SteamAppId from a process environment, or 0.
+func steamAppID(env map[string]string) int {
+	id, err := strconv.Atoi(strings.TrimSpace(env["SteamAppId"]))
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
 }
 
-// readSteamAppID reads the SteamAppId value from a /proc/[pid]/environ file.
-// Returns 0 if not found. Returns an error if the file can't be read (e.g. wrong user).
-func readSteamAppID(path string) (int, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		// Permission denied = another user's process, skip silently
-		return 0, err
-	}
-	// environ is NUL-separated key=value pairs
-	for _, entry := range strings.Split(string(data), "\x00") {
-		if strings.HasPrefix(entry, "SteamAppId=") {
-			val := strings.TrimPrefix(entry, "SteamAppId=")
-			id, err := strconv.Atoi(strings.TrimSpace(val))
-			if err != nil {
-				return 0, err
-			}
-			if id > 0 {
-				return id, nil
-			}
+// windowBackend reports the focused window of the desktop session.
+type windowBackend interface {
+	// activeWindow returns the PID and title of the focused window, or a
+	// zero PID when nothing is focused.
+	activeWindow() (int, string, error)
+}
+
+// focusNotifier is implemented by backends that push focus changes.
+type focusNotifier interface {
+	focusChanges() <-chan struct{}
+}
+
+// windowLister is implemented by backends that can list every window's owner.
+type windowLister interface {
+	windowPIDs() (map[int]bool, error)
+}
+
+var activeWindowBackend = sync.OnceValue(selectWindowBackend)
+
+// getWindowPIDs returns the PIDs owning a window, or just the focused one if
+// the backend can't list windows.
+func getWindowPIDs() map[int]bool {
+	b := activeWindowBackend()
+	if l, ok := b.(windowLister); ok {
+		if pids, err := l.windowPIDs(); err == nil {
+			return pids
 		}
 	}
-	return 0, nil
+	pids := make(map[int]bool)
+	if pid, _, err := b.activeWindow(); err == nil && pid > 0 {
+		pids[pid] = true
+	}
+	return pids
+}
+
+// windowFocusEvents returns a channel that fires when the focused window
+// changes, or nil if the backend can only be polled.
+func windowFocusEvents() <-chan struct{} {
+	if n, ok := activeWindowBackend().(focusNotifier); ok {
+		return n.focusChanges()
+	}
+	return nil
 }
 
-// getActiveWindowInfo returns the process name and window title of the focused window.
-// Requires xdotool. Does not work on Wayland.
-func getActiveWindowInfo() (string, string, error) {
-	if os.Getenv("WAYLAND_DISPLAY") != "" && os.Getenv("DISPLAY") == "" {
-		return "", "", fmt.Errorf("wayland-only session: active window detection not supported")
+// getActiveWindowInfo returns the PID, process name and window title of the
+// focused window.
+func getActiveWindowInfo() (int, string, string, error) {
+	pid, title, err := activeWindowBackend().activeWindow()
+	if err != nil || pid <= 0 {
+		return 0, "", title, err
 	}
 
+	comm, err := procComm(pid)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return pid, processName(pid, comm), title, nil
+}
+
+// procComm returns the process name of pid.
+func procComm(pid int) (string, error) {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// xdotoolBackend queries X11 (or XWayland) through the xdotool command.
+type xdotoolBackend struct{}
+
+func (xdotoolBackend) activeWindow() (int, string, error) {
 	out, err := exec.Command("xdotool", "getactivewindow").Output()
 	if err != nil {
-		return "", "", fmt.Errorf("xdotool not available: %w", err)
+		return 0, "", fmt.Errorf("xdotool not available: %w", err)
 	}
 	winID := strings.TrimSpace(string(out))
 
 	out, err = exec.Command("xdotool", "getwindowpid", winID).Output()
 	if err != nil {
-		return "", "", fmt.Errorf("xdotool getwindowpid: %w", err)
+		return 0, "", fmt.Errorf("xdotool getwindowpid: %w", err)
 	}
 	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
 	if err != nil {
-		return "", "", err
-	}
-
-	commPath := fmt.Sprintf("/proc/%d/comm", pid)
-	comm, err := os.ReadFile(commPath)
-	if err != nil {
-		return "", "", err
+		return 0, "", err
 	}
-	procName := strings.TrimSpace(string(comm))
 
 	out, err = exec.Command("xdotool", "getwindowname", winID).Output()
 	if err != nil {
-		return procName, "", nil
+		return pid, "", nil
 	}
 	title := strings.TrimSpace(string(out))
 
-	return procName, title, nil
+	return pid, title, nil
 }
+
+// unsupportedBackend is used when no way to query the focused window exists.
+type unsupportedBackend struct{ err error }
+
+func (b unsupportedBackend) activeWindow() (int, string, error) { return 0, "", b.err }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type trackerHarness struct {
+	clock   *fakeClock
+	procs   *fakeProcesses
+	buf     *SessionBuffer
+	tracker *Tracker
+	cfg     *Config
+	changes []string
+}
+
+func newTrackerHarness(t *testing.T) *trackerHarness {
+	t.Helper()
+	h := &trackerHarness{
+		clock: newFakeClock(),
+		procs: &fakeProcesses{},
+		cfg: &Config{
+			Games:         []GameEntry{{Process: "factorio", Name: "Factorio"}},
+			ScanProcesses: true,
+		},
+	}
+	h.buf = newTestBuffer(t, h.cfg.sessionPolicy(nil), h.clock)
+	detector := &Detector{procs: h.procs, steam: fakeSteam{730: "Counter-Strike 2"}}
+	h.tracker = newTracker(detector, h.buf, func(g *DetectedGame) {
+		if g == nil {
+			h.changes = append(h.changes, "")
+			return
+		}
+		h.changes = append(h.changes, g.Name)
+	})
+	return h
+}
+
+// tickAt moves the clock to offset and runs one detection.
+func (h *trackerHarness) tickAt(offset time.Duration) {
+	h.clock.Set(offset)
+	h.tracker.Tick(h.cfg)
+}
+
+func TestTrackerStartStop(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.tickAt(0)
+	h.procs.steamAppID, h.procs.steamProcess = 730, "cs2"
+	h.tickAt(3 * time.Second)
+	h.tickAt(10 * time.Minute)
+	h.procs.steamAppID = 0
+	h.tickAt(20 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Counter-Strike 2", ""})
+	got := h.buf.Drain()
+	assertSessions(t, got, []wantSession{{"Counter-Strike 2", 3 * time.Second, 20 * time.Minute, 20*time.Minute - 3*time.Second}})
+	if g := got[0].Game; g.Source != "steam" || g.SteamAppID != 730 || g.Process != "cs2" {
+		t.Errorf("unexpected game %+v", g)
+	}
+}
+
+func TestTrackerSwitch(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.procs.window = "factorio"
+	h.tickAt(0)
+	h.procs.steamAppID = 730
+	h.tickAt(5 * time.Minute)
+	h.procs.steamAppID = 0
+	h.tickAt(10 * time.Minute)
+	h.procs.window = ""
+	h.tickAt(15 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", "Counter-Strike 2", "Factorio", ""})
+	assertSessions(t, h.buf.Drain(), []wantSession{
+		{"Factorio", 0, 5 * time.Minute, 5 * time.Minute},
+		{"Counter-Strike 2", 5 * time.Minute, 10 * time.Minute, 5 * time.Minute},
+		{"Factorio", 10 * time.Minute, 15 * time.Minute, 5 * time.Minute},
+	})
+}
+
+func TestTrackerUnknownSteamApp(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.procs.steamAppID = 12345
+	h.tickAt(0)
+
+	assertChanges(t, h.changes, []string{"Steam App 12345"})
+}
+
+func TestTrackerSteamGameRenamed(t *testing.T) {
+	h := newTrackerHarness(t)
+	h.cfg.Games = append(h.cfg.Games, GameEntry{SteamAppID: 730, Name: "CS2"})
+
+	h.procs.steamAppID = 730
+	h.tickAt(0)
+	h.tickAt(time.Minute)
+
+	assertChanges(t, h.changes, []string{"CS2"})
+}
+
+func TestTrackerBackgroundGameFocus(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.procs.window = "factorio"
+	h.procs.running = []string{"bash", "factorio"}
+	h.tickAt(0)
+	h.procs.window = "discord"
+	h.tickAt(10 * time.Minute)
+	h.procs.window = "factorio"
+	h.tickAt(12 * time.Minute)
+	h.procs.window, h.procs.running = "", nil
+	h.tickAt(20 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", ""})
+	got := h.buf.Drain()
+	assertSessions(t, got, []wantSession{{"Factorio", 0, 20 * time.Minute, 20 * time.Minute}})
+	if want := (18 * time.Minute).Seconds(); got[0].Focused != want {
+		t.Errorf("focused %.0fs, want %.0fs", got[0].Focused, want)
+	}
+}
+
+func TestTrackerSteamGameFocus(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.procs.steamAppID, h.procs.steamPIDs = 730, []int{42, 40}
+	h.procs.windowPID = 40
+	h.tickAt(0)
+	h.procs.windowPID = 7 // alt-tabbed to another program
+	h.tickAt(10 * time.Minute)
+	h.procs.windowPID = 42
+	h.tickAt(15 * time.Minute)
+	h.procs.steamAppID = 0
+	h.tickAt(20 * time.Minute)
+
+	got := h.buf.Drain()
+	assertSessions(t, got, []wantSession{{"Counter-Strike 2", 0, 20 * time.Minute, 20 * time.Minute}})
+	if want := (15 * time.Minute).Seconds(); got[0].Focused != want {
+		t.Errorf("focused %.0fs, want %.0fs", got[0].Focused, want)
+	}
+}
+
+func TestTrackerBackgroundGameWithoutScan(t *testing.T) {
+	h := newTrackerHarness(t)
+	h.cfg.ScanProcesses = false
+
+	h.procs.window = "factorio"
+	h.procs.running = []string{"factorio"}
+	h.tickAt(0)
+	h.procs.window = "discord"
+	h.tickAt(10 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", ""})
+}
+
+func TestTrackerBackgroundPrefersCurrentGame(t *testing.T) {
+	h := newTrackerHarness(t)
+	h.cfg.Games = []GameEntry{
+		{Process: "secret", Name: "Secret Game"},
+		{Process: "factorio", Name: "Factorio"},
+		{Process: "RimWorldWin64", Name: "RimWorld"},
+	}
+	h.cfg.Privacy.Exclude = []string{"Secret Game"}
+
+	h.procs.running = []string{"secret", "factorio", "RimWorldWin64"}
+	h.procs.window = "RimWorldWin64"
+	h.tickAt(0)
+	// RimWorld keeps running in the background, it isn't swapped for Factorio
+	h.procs.window = "discord"
+	h.tickAt(time.Minute)
+	// Once it exits, Factorio is picked up rather than the excluded game
+	h.procs.running = []string{"secret", "factorio"}
+	h.tickAt(2 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"RimWorld", "Factorio"})
+}
+
+func TestTrackerExcludedSteamGame(t *testing.T) {
+	h := newTrackerHarness(t)
+	h.cfg.Privacy.Exclude = []string{"Counter-Strike 2"}
+
+	// Counter-Strike 2 idles in the background while Factorio is played
+	h.procs.steamAppID = 730
+	h.procs.window = "factorio"
+	h.tickAt(0)
+	h.procs.window = ""
+	h.tickAt(10 * time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", ""})
+	assertSessions(t, h.buf.Drain(), []wantSession{{"Factorio", 0, 10 * time.Minute, 10 * time.Minute}})
+}
+
+func TestTrackerSuspend(t *testing.T) {
+	h := newTrackerHarness(t)
+
+	h.procs.window = "factorio"
+	h.tickAt(0)
+	h.tickAt(5 * time.Minute)
+	// Asleep for two hours, noticed on the first tick after waking
+	h.clock.Set(2 * time.Hour)
+	h.tracker.Suspend(testEpoch.Add(5 * time.Minute))
+	h.tickAt(2 * time.Hour)
+	h.procs.window = ""
+	h.tickAt(2*time.Hour + 10*time.Minute)
+
+	assertChanges(t, h.changes, []string{"Factorio", "", "Factorio", ""})
+	assertSessions(t, h.buf.Drain(), []wantSession{
+		{"Factorio", 0, 5 * time.Minute, 5 * time.Minute},
+		{"Factorio", 2 * time.Hour, 2*time.Hour + 10*time.Minute, 10 * time.Minute},
+	})
+}
+
+func TestTrackerCheckClock(t *testing.T) {
+	h := newTrackerHarness(t)
+	// check moves the wall clock to offset and the time since boot to up
+	check := func(offset, up time.Duration) {
+		h.clock.Set(offset)
+		h.tracker.CheckClock(h.clock.Now(), up)
+		h.tracker.Tick(h.cfg)
+	}
+
+	h.procs.window = "factorio"
+	check(0, 0)
+	check(30*time.Second, 30*time.Second)
+	// Clock set an hour forward between two detections
+	check(time.Hour+40*time.Second, 40*time.Second)
+	check(time.Hour+time.Minute, time.Minute)
+	// Asleep for two hours without an announcement
+	check(3*time.Hour+time.Minute, 2*time.Hour+time.Minute)
+	// A few seconds of drift is no jump
+	check(3*time.Hour+time.Minute+50*time.Second, 2*time.Hour+time.Minute+45*time.Second)
+	h.procs.window = ""
+	check(3*time.Hour+2*time.Minute, 2*time.Hour+time.Minute+55*time.Second)
+
+	assertChanges(t, h.changes, []string{"Factorio", "", "Factorio", ""})
+	got := h.buf.Drain()
+	assertSessions(t, got, []wantSession{
+		{"Factorio", time.Hour, time.Hour + time.Minute, time.Minute},
+		{"Factorio", 3*time.Hour + time.Minute, 3*time.Hour + 2*time.Minute, time.Minute},
+	})
+	if !got[0].ClockAdjusted || got[1].ClockAdjusted {
+		t.Errorf("clock_adjusted %v, %v; want true, false", got[0].ClockAdjusted, got[1].ClockAdjusted)
+	}
+}
+
+// reportServer records reports and fails them while failing is set.
+type reportServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failing  bool
+	received []Report
+}
+
+func newReportServer(t *testing.T) *reportServer {
+	rs := &reportServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		if rs.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var rep Report
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			t.Errorf("decode report: %v", err)
+		}
+		rs.received = append(rs.received, rep)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *reportServer) setFailing(failing bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.failing = failing
+}
+
+func (rs *reportServer) reports() []Report {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return slices.Clone(rs.received)
+}
+
+func TestTrackerReportSinks(t *testing.T) {
+	up, down := newReportServer(t), newReportServer(t)
+	down.setFailing(true)
+
+	h := newTrackerHarness(t)
+	h.cfg.Sinks = []SinkConfig{{Name: "up", URL: up.URL}, {Name: "down", URL: down.URL}}
+	outbox := newOutbox(t.TempDir(), h.clock)
+
+	h.procs.steamAppID = 730
+	h.tickAt(0)
+	h.procs.steamAppID = 0
+	h.tickAt(30 * time.Minute)
+	h.clock.Set(time.Hour)
+
+	if _, err := flushSessions(context.Background(), h.buf, outbox, h.cfg, false); err == nil {
+		t.Fatal("expected the down sink to fail")
+	}
+	if h.buf.HasPending() {
+		t.Fatal("session left in the buffer after handing it to the outbox")
+	}
+	if !outbox.HasPending(h.cfg.Sinks[1:]) {
+		t.Fatal("session dropped for the failed sink")
+	}
+	if outbox.HasPending(h.cfg.Sinks[:1]) {
+		t.Fatal("session still pending for the working sink")
+	}
+
+	down.setFailing(false)
+	if _, err := flushSessions(context.Background(), h.buf, outbox, h.cfg, true); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if outbox.HasPending(h.cfg.Sinks) {
+		t.Fatal("sessions still pending after successful report")
+	}
+
+	want := []wantSession{{"Counter-Strike 2", 0, 30 * time.Minute, 30 * time.Minute}}
+	for name, rs := range map[string]*reportServer{"up": up, "down": down} {
+		got := rs.reports()
+		if len(got) != 1 {
+			t.Fatalf("%s received %d reports, want 1", name, len(got))
+		}
+		assertSessions(t, got[0].Sessions, want)
+	}
+}
+
+func TestReportBeforeShutdown(t *testing.T) {
+	rs := newReportServer(t)
+	h := newTrackerHarness(t)
+	h.cfg.Sinks = []SinkConfig{{Name: "up", URL: rs.URL}}
+
+	prevCfg, prevBuf, prevOutbox := cfg.Load(), buf, outbox
+	t.Cleanup(func() {
+		cfg.Store(prevCfg)
+		buf, outbox = prevBuf, prevOutbox
+		netState.Store(nil)
+	})
+	cfg.Store(h.cfg)
+	buf, outbox = h.buf, newOutbox(t.TempDir(), h.clock)
+
+	h.procs.steamAppID = 730
+	h.tickAt(0)
+	h.clock.Set(30 * time.Minute)
+
+	// Offline the running game is saved, and sent once the agent starts again
+	netState.Store(&NetworkState{Known: true})
+	reportBeforeShutdown(context.Background())
+	if len(rs.reports()) != 0 || !buf.HasPending() {
+		t.Fatalf("offline: %d reports, pending %v", len(rs.reports()), buf.HasPending())
+	}
+
+	netState.Store(&NetworkState{Known: true, Online: true})
+	reportBeforeShutdown(context.Background())
+	if got := rs.reports(); len(got) != 1 {
+		t.Fatalf("online: %d reports, want 1", len(got))
+	} else {
+		assertSessions(t, got[0].Sessions, []wantSession{{"Counter-Strike 2", 0, 30 * time.Minute, 30 * time.Minute}})
+	}
+}
+
+func assertChanges(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got changes %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got changes %q, want %q", got, want)
+		}
+	}
+}
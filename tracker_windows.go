@@ -25,16 +25,16 @@ const (
 
 // getSteamRunningApp reads the currently active Steam game from the registry.
 // Steam writes the running App ID to HKCU\SOFTWARE\Valve\Steam\ActiveProcess\ActiveGameId.
-func getSteamRunningApp() (int, string, error) {
+func getSteamRunningApp() (SteamApp, error) {
 	var k syscall.Handle
 	path, err := syscall.UTF16PtrFromString(`SOFTWARE\Valve\Steam\ActiveProcess`)
 	if err != nil {
-		return 0, "", err
+		return SteamApp{}, err
 	}
 
 	err = syscall.RegOpenKeyEx(syscall.HKEY_CURRENT_USER, path, 0, syscall.KEY_READ, &k)
 	if err != nil {
-		return 0, "", fmt.Errorf("steam registry key not found: %w", err)
+		return SteamApp{}, fmt.Errorf("steam registry key not found: %w", err)
 	}
 	defer syscall.RegCloseKey(k)
 
@@ -44,23 +44,50 @@ func getSteamRunningApp() (int, string, error) {
 	name, _ := syscall.UTF16PtrFromString("ActiveGameId")
 	err = syscall.RegQueryValueEx(k, name, nil, &valType, &buf[0], &bufLen)
 	if err != nil {
-		return 0, "", fmt.Errorf("ActiveGameId not found: %w", err)
+		return SteamApp{}, fmt.Errorf("ActiveGameId not found: %w", err)
 	}
 
 	// REG_DWORD is little-endian
 	appID := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16 | int(buf[3])<<24
 	if appID == 0 {
-		return 0, "", fmt.Errorf("no active steam game")
+		return SteamApp{}, fmt.Errorf("no active steam game")
 	}
 
-	return appID, "", nil
+	return SteamApp{AppID: appID}, nil
 }
 
-// getActiveWindowInfo returns the process name and title of the foreground window.
-func getActiveWindowInfo() (string, string, error) {
+// getRunningProcesses returns the executable names (without .exe) of all running processes.
+func getRunningProcesses() ([]string, error) {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("process snapshot: %w", err)
+	}
+	defer syscall.CloseHandle(snap)
+
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snap, &entry); err != nil {
+		return nil, fmt.Errorf("process32first: %w", err)
+	}
+
+	var names []string
+	for {
+		name := baseNameNoExt(syscall.UTF16ToString(entry.ExeFile[:]))
+		if name != "" {
+			names = append(names, name)
+		}
+		if err := syscall.Process32Next(snap, &entry); err != nil {
+			break
+		}
+	}
+	return names, nil
+}
+
+// getActiveWindowInfo returns the PID, process name and title of the foreground window.
+func getActiveWindowInfo() (int, string, string, error) {
 	hwnd, _, _ := procGetForegroundWindow.Call()
 	if hwnd == 0 {
-		return "", "", nil
+		return 0, "", "", nil
 	}
 
 	// Get window title
@@ -72,13 +99,13 @@ func getActiveWindowInfo() (string, string, error) {
 	var pid uint32
 	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
 	if pid == 0 {
-		return "", title, nil
+		return 0, "", title, nil
 	}
 
 	// Get process image name
 	handle, _, _ := procOpenProcess.Call(processQueryLimitedInformation, 0, uintptr(pid))
 	if handle == 0 {
-		return "", title, nil
+		return int(pid), "", title, nil
 	}
 	defer procCloseHandle.Call(handle)
 
@@ -86,29 +113,22 @@ func getActiveWindowInfo() (string, string, error) {
 	nameLen := uint32(len(nameBuf))
 	ret, _, _ := procQueryFullProcessImageName.Call(handle, 0, uintptr(unsafe.Pointer(&nameBuf[0])), uintptr(unsafe.Pointer(&nameLen)))
 	if ret == 0 {
-		return "", title, nil
+		return int(pid), "", title, nil
 	}
 
 	fullPath := syscall.UTF16ToString(nameBuf[:nameLen])
 	// Extract just the filename without extension
 	procName := baseNameNoExt(fullPath)
 
-	return procName, title, nil
+	return int(pid), procName, title, nil
 }
 
-func baseNameNoExt(path string) string {
-	// Find last backslash or forward slash
-	last := -1
-	for i := len(path) - 1; i >= 0; i-- {
-		if path[i] == '\\' || path[i] == '/' {
-			last = i
-			break
-		}
-	}
-	name := path[last+1:]
-	// Strip .exe
-	if len(name) > 4 && name[len(name)-4:] == ".exe" {
-		name = name[:len(name)-4]
-	}
-	return name
+// windowFocusEvents returns nil: the foreground window is polled on Windows.
+func windowFocusEvents() <-chan struct{} {
+	return nil
+}
+
+// processEvents returns nil: processes are polled on Windows.
+func processEvents() <-chan struct{} {
+	return nil
 }
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+var pauseDurations = []struct {
+	title string
+	d     time.Duration // 0 pauses until resumed
+}{
+	{"15 minutes", 15 * time.Minute},
+	{"1 hour", time.Hour},
+	{"4 hours", 4 * time.Hour},
+	{"Until resumed", 0},
+}
+
+// trayMenu holds the tray menu items and acts on their clicks.
+type trayMenu struct {
+	tracker *Tracker
+	playing atomic.Pointer[DetectedGame]
+
+	status     *systray.MenuItem
+	configErr  *systray.MenuItem
+	network    *systray.MenuItem
+	dontTrack  *systray.MenuItem
+	rename     *systray.MenuItem
+	addWindow  *systray.MenuItem
+	pause      *systray.MenuItem
+	pauseItems []*systray.MenuItem
+	resume     *systray.MenuItem
+	profile    *systray.MenuItem
+	profiles   []*systray.MenuItem
+	recent     *systray.MenuItem
+	recentList []*systray.MenuItem
+	push       *systray.MenuItem
+	quit       *systray.MenuItem
+
+	mu           sync.Mutex
+	paused       bool
+	pauseTimer   *time.Timer
+	pauseGen     int       // bumped on every pause or resume, to ignore stale timers
+	pausedUntil  time.Time // zero while paused indefinitely
+	profileNames []string
+}
+
+// maxTrayProfiles is how many profiles the tray can switch between.
+const maxTrayProfiles = 8
+
+func newTrayMenu() *trayMenu {
+	m := &trayMenu{}
+	m.status = systray.AddMenuItem("Not playing", "Currently detected game")
+	m.status.Disable()
+	systray.AddSeparator()
+	m.configErr = systray.AddMenuItem("", "config.json has errors, see the log")
+	m.configErr.Disable()
+	m.configErr.Hide()
+	m.network = systray.AddMenuItem("", "Sessions are sent once the connection allows it")
+	m.network.Disable()
+	m.network.Hide()
+
+	m.dontTrack = systray.AddMenuItem("Don't track this game", "Add the current game to privacy.exclude")
+	m.dontTrack.Hide()
+	m.rename = systray.AddMenuItem("Rename this game…", "Set the name the current game is reported as")
+	m.rename.Hide()
+	m.addWindow = systray.AddMenuItem("Add current window as game…", "Track the program of the window you switch to next")
+
+	m.pause = systray.AddMenuItem("Pause tracking", "Don't record anything for a while")
+	for _, p := range pauseDurations {
+		m.pauseItems = append(m.pauseItems, m.pause.AddSubMenuItem(p.title, ""))
+	}
+	m.resume = systray.AddMenuItem("Resume tracking", "")
+	m.resume.Hide()
+
+	m.profile = systray.AddMenuItem("Profile", "Who is playing")
+	for range maxTrayProfiles {
+		item := m.profile.AddSubMenuItemCheckbox("", "", false)
+		item.Hide()
+		m.profiles = append(m.profiles, item)
+	}
+	m.profile.Hide()
+
+	m.recent = systray.AddMenuItem("Recent sessions", "Sessions recorded since the agent started")
+	for range maxRecentSessions {
+		item := m.recent.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.recentList = append(m.recentList, item)
+	}
+	m.recent.Disable()
+
+	m.push = systray.AddMenuItem("Push update", "Send pending sessions now")
+	systray.AddSeparator()
+	m.quit = systray.AddMenuItem("Quit", "Stop tracking")
+	return m
+}
+
+func (m *trayMenu) showConfigError(err error) {
+	m.configErr.SetTitle("Config error: " + firstLine(err.Error()))
+	m.configErr.Show()
+}
+
+func (m *trayMenu) hideConfigError() {
+	m.configErr.Hide()
+}
+
+// setNetwork shows whether reports are being held back by the connection.
+func (m *trayMenu) setNetwork(s NetworkState) {
+	switch {
+	case s.allowsReporting(cfg.Load()):
+		m.network.Hide()
+	case !s.Online:
+		m.network.SetTitle("Offline — reports held")
+		m.network.Show()
+	default:
+		m.network.SetTitle("Metered connection — reports held")
+		m.network.Show()
+	}
+}
+
+// gameChanged is called by the tracker with the game being played, nil when
+// nothing is.
+func (m *trayMenu) gameChanged(g *DetectedGame) {
+	m.playing.Store(g)
+	m.updateStatus()
+	if g == nil {
+		m.dontTrack.Hide()
+		m.rename.Hide()
+	} else {
+		m.dontTrack.Show()
+		m.rename.Show()
+	}
+	m.updateRecent()
+}
+
+func (m *trayMenu) updateStatus() {
+	m.mu.Lock()
+	paused := m.paused
+	m.mu.Unlock()
+	switch g := m.playing.Load(); {
+	case paused:
+		m.status.SetTitle("Tracking paused")
+	case g == nil:
+		m.status.SetTitle("Not playing")
+	default:
+		m.status.SetTitle("Playing: " + g.Name)
+	}
+}
+
+// statusText describes what the agent is doing, for `dazuukiknie-agent status`.
+func (m *trayMenu) statusText() string {
+	m.mu.Lock()
+	paused := m.paused
+	m.mu.Unlock()
+	var b strings.Builder
+	switch g := m.playing.Load(); {
+	case paused:
+		b.WriteString("tracking paused\n")
+	case g == nil:
+		b.WriteString("not playing\n")
+	default:
+		fmt.Fprintf(&b, "playing: %s\n", g.Name)
+	}
+	c := cfg.Load()
+	if c.Profile != "" {
+		fmt.Fprintf(&b, "profile: %s\n", c.Profile)
+	}
+	unsent := "none"
+	if buf.HasPending() || outbox.HasPending(c.Sinks) {
+		unsent = "waiting to be sent"
+	}
+	fmt.Fprintf(&b, "unsent sessions: %s", unsent)
+	return b.String()
+}
+
+func (m *trayMenu) updateRecent() {
+	sessions := buf.Recent()
+	for i, item := range m.recentList {
+		if i >= len(sessions) {
+			item.Hide()
+			continue
+		}
+		s := sessions[i]
+		played := (time.Duration(s.Duration) * time.Second).Round(time.Minute)
+		item.SetTitle(fmt.Sprintf("%s — %s (%s)", s.Game.Name, formatPlayed(played), s.StartedAt.Local().Format("Mon 15:04")))
+		item.Show()
+	}
+	if len(sessions) > 0 {
+		m.recent.Enable()
+	}
+}
+
+// formatPlayed formats a play time as e.g. 1h05m or 12m.
+func formatPlayed(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// setProfiles shows the configured profiles, checking the active one.
+func (m *trayMenu) setProfiles(c *Config) {
+	names := c.Profiles[:min(len(c.Profiles), maxTrayProfiles)]
+	m.mu.Lock()
+	m.profileNames = names
+	m.mu.Unlock()
+
+	if len(names) == 0 {
+		m.profile.Hide()
+		return
+	}
+	title := "Profile: " + c.Profile
+	if c.Profile == "" {
+		title = "Profile: nobody"
+	}
+	m.profile.SetTitle(title)
+	m.profile.Show()
+	for i, item := range m.profiles {
+		if i >= len(names) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(names[i])
+		if names[i] == c.Profile {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+		item.Show()
+	}
+}
+
+func (m *trayMenu) switchProfile(i int) {
+	m.mu.Lock()
+	if i >= len(m.profileNames) {
+		m.mu.Unlock()
+		return
+	}
+	name := m.profileNames[i]
+	m.mu.Unlock()
+
+	if err := updateConfig(func(c *Config) { c.Profile = name }); err != nil {
+		log.Printf("Can't switch profile: %v", err)
+		m.showConfigError(err)
+		return
+	}
+	log.Printf("Switched profile to %s", name)
+}
+
+func (m *trayMenu) setPaused(d time.Duration, paused bool) {
+	m.mu.Lock()
+	m.applyPause(d, paused)
+	m.mu.Unlock()
+	m.showPaused()
+}
+
+// applyPause must be called with m.mu held. A timer that already fired can
+// no longer be stopped and may be waiting on m.mu, so it carries the
+// generation it was started in and does nothing once that has passed.
+func (m *trayMenu) applyPause(d time.Duration, paused bool) {
+	if m.pauseTimer != nil {
+		m.pauseTimer.Stop()
+		m.pauseTimer = nil
+	}
+	m.pauseGen++
+	m.paused, m.pausedUntil = paused, time.Time{}
+	m.tracker.SetPaused(paused)
+	switch {
+	case !paused:
+		log.Printf("Tracking resumed")
+	case d > 0:
+		m.pausedUntil = time.Now().Add(d)
+		gen := m.pauseGen
+		m.pauseTimer = time.AfterFunc(d, func() {
+			m.mu.Lock()
+			if m.pauseGen != gen {
+				m.mu.Unlock()
+				return
+			}
+			m.applyPause(0, false)
+			m.mu.Unlock()
+			m.showPaused()
+		})
+		log.Printf("Tracking paused until %s", m.pausedUntil.Format("15:04"))
+	default:
+		log.Printf("Tracking paused")
+	}
+}
+
+// showPaused updates the menu to the current pause state.
+func (m *trayMenu) showPaused() {
+	m.mu.Lock()
+	paused, until := m.paused, m.pausedUntil
+	m.mu.Unlock()
+
+	switch {
+	case !paused:
+		m.pause.Show()
+		m.resume.Hide()
+	case !until.IsZero():
+		m.pause.Hide()
+		m.resume.SetTitle("Resume tracking (paused until " + until.Format("15:04") + ")")
+		m.resume.Show()
+	default:
+		m.pause.Hide()
+		m.resume.SetTitle("Resume tracking")
+		m.resume.Show()
+	}
+	m.updateStatus()
+}
+
+func (m *trayMenu) excludeCurrent() {
+	g := m.playing.Load()
+	if g == nil {
+		return
+	}
+	err := updateConfig(func(c *Config) {
+		c.Privacy.Exclude = append(c.Privacy.Exclude, g.Name)
+	})
+	if err != nil {
+		log.Printf("Can't exclude %s: %v", g.Name, err)
+		m.showConfigError(err)
+		return
+	}
+	log.Printf("No longer tracking %s", g.Name)
+}
+
+func (m *trayMenu) renameCurrent() {
+	g := m.playing.Load()
+	if g == nil {
+		return
+	}
+	name, ok, err := promptText("Rename game", "Report "+g.Name+" as:", g.Name)
+	if err != nil {
+		log.Printf("Rename failed: %v", err)
+		return
+	}
+	if !ok || name == g.Name {
+		return
+	}
+	if err := updateConfig(func(c *Config) { c.setGameName(g.Process, g.SteamAppID, name) }); err != nil {
+		log.Printf("Can't rename %s: %v", g.Name, err)
+		m.showConfigError(err)
+		return
+	}
+	log.Printf("Renamed %s to %s", g.Name, name)
+}
+
+// addCurrentWindow gives the user a few seconds to focus the game, then adds
+// the program owning the focused window to the configured games.
+func (m *trayMenu) addCurrentWindow() {
+	m.addWindow.Disable()
+	defer func() {
+		m.addWindow.SetTitle("Add current window as game…")
+		m.addWindow.Enable()
+	}()
+	for i := 5; i > 0; i-- {
+		m.addWindow.SetTitle(fmt.Sprintf("Switch to the game window… %d", i))
+		time.Sleep(time.Second)
+	}
+
+	_, proc, title, err := getActiveWindowInfo()
+	if err != nil || proc == "" {
+		log.Printf("No active window to add: %v", err)
+		return
+	}
+	if title == "" {
+		title = baseNameNoExt(proc)
+	}
+	name, ok, err := promptText("Add game", "Track "+baseNameNoExt(proc)+" as:", title)
+	if err != nil {
+		log.Printf("Add game failed: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if err := updateConfig(func(c *Config) { c.setGameName(proc, 0, name) }); err != nil {
+		log.Printf("Can't add %s: %v", proc, err)
+		m.showConfigError(err)
+		return
+	}
+	log.Printf("Added %s as %s", baseNameNoExt(proc), name)
+}
+
+func (m *trayMenu) run(ctx context.Context) {
+	// Sessions are recorded once the merge gap has passed, not when the game stops
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	pauses := make(chan time.Duration)
+	for i, item := range m.pauseItems {
+		go func() {
+			for range item.ClickedCh {
+				pauses <- pauseDurations[i].d
+			}
+		}()
+	}
+
+	profiles := make(chan int)
+	for i, item := range m.profiles {
+		go func() {
+			for range item.ClickedCh {
+				profiles <- i
+			}
+		}()
+	}
+
+	for {
+		select {
+		case i := <-profiles:
+			go m.switchProfile(i)
+		case d := <-pauses:
+			m.setPaused(d, true)
+		case <-m.resume.ClickedCh:
+			m.setPaused(0, false)
+		case <-m.dontTrack.ClickedCh:
+			go m.excludeCurrent()
+		case <-m.rename.ClickedCh:
+			go m.renameCurrent()
+		case <-m.addWindow.ClickedCh:
+			go m.addCurrentWindow()
+		case <-m.push.ClickedCh:
+			go forcePush()
+		case <-m.quit.ClickedCh:
+			systray.Quit()
+		case <-ticker.C:
+			m.updateRecent()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
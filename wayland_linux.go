@@ -0,0 +1,454 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// selectWindowBackend picks how to query the focused window from the session
+// environment. Native Wayland compositors are preferred over X11, which only
+// sees XWayland windows there.
+func selectWindowBackend() windowBackend {
+	desktop := strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP"))
+	wayland := os.Getenv("WAYLAND_DISPLAY") != ""
+
+	var b windowBackend
+	var name string
+	switch {
+	case os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "":
+		b, name = hyprlandBackend{}, "Hyprland IPC"
+	case os.Getenv("SWAYSOCK") != "":
+		b, name = swayBackend{socket: os.Getenv("SWAYSOCK")}, "sway IPC"
+	case wayland && strings.Contains(desktop, "GNOME"):
+		b, name = &gnomeBackend{}, "GNOME Shell D-Bus"
+	case wayland && strings.Contains(desktop, "KDE"):
+		b, name = &kwinBackend{}, "KWin scripting"
+	case os.Getenv("DISPLAY") != "":
+		x, err := newX11Backend()
+		if err != nil {
+			log.Printf("Native X11 unavailable (%v), falling back to xdotool", err)
+			b, name = xdotoolBackend{}, "xdotool"
+			break
+		}
+		b, name = x, "X11"
+	default:
+		return unsupportedBackend{fmt.Errorf("wayland session on %q: active window detection not supported", desktop)}
+	}
+	log.Printf("Active window detection: %s", name)
+	return b
+}
+
+// swayBackend asks sway (or another i3-ipc compatible compositor) for its
+// window tree, like `swaymsg -t get_tree`.
+type swayBackend struct {
+	socket string
+}
+
+const swayGetTree = 4
+
+type swayNode struct {
+	Focused       bool       `json:"focused"`
+	PID           int        `json:"pid"`
+	Name          string     `json:"name"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+func (b swayBackend) activeWindow() (int, string, error) {
+	root, err := b.tree()
+	if err != nil {
+		return 0, "", err
+	}
+	if n := findFocusedSwayNode(root); n != nil {
+		return n.PID, n.Name, nil
+	}
+	return 0, "", nil
+}
+
+func (b swayBackend) windowPIDs() (map[int]bool, error) {
+	root, err := b.tree()
+	if err != nil {
+		return nil, err
+	}
+	pids := make(map[int]bool)
+	var walk func(n *swayNode)
+	walk = func(n *swayNode) {
+		if n.PID > 0 {
+			pids[n.PID] = true
+		}
+		for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+			for i := range children {
+				walk(&children[i])
+			}
+		}
+	}
+	walk(root)
+	return pids, nil
+}
+
+func (b swayBackend) tree() (*swayNode, error) {
+	conn, err := net.DialTimeout("unix", b.socket, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("sway ipc: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// i3-ipc message: magic, payload length, type, payload
+	req := make([]byte, 14)
+	copy(req, "i3-ipc")
+	binary.NativeEndian.PutUint32(req[6:], 0)
+	binary.NativeEndian.PutUint32(req[10:], swayGetTree)
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("sway ipc write: %w", err)
+	}
+
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("sway ipc read: %w", err)
+	}
+	if string(header[:6]) != "i3-ipc" {
+		return nil, fmt.Errorf("sway ipc: bad reply header")
+	}
+	payload := make([]byte, binary.NativeEndian.Uint32(header[6:]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, fmt.Errorf("sway ipc read: %w", err)
+	}
+
+	var root swayNode
+	if err := json.Unmarshal(payload, &root); err != nil {
+		return nil, fmt.Errorf("sway tree: %w", err)
+	}
+	return &root, nil
+}
+
+func findFocusedSwayNode(n *swayNode) *swayNode {
+	if n.Focused {
+		return n
+	}
+	for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+		for i := range children {
+			if f := findFocusedSwayNode(&children[i]); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// hyprlandBackend queries Hyprland's request socket.
+type hyprlandBackend struct{}
+
+func (b hyprlandBackend) activeWindow() (int, string, error) {
+	data, err := b.request("j/activewindow")
+	if err != nil {
+		return 0, "", err
+	}
+	var win struct {
+		PID   int    `json:"pid"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(data, &win); err != nil {
+		return 0, "", fmt.Errorf("hyprland activewindow: %w", err)
+	}
+	return win.PID, win.Title, nil
+}
+
+func (b hyprlandBackend) windowPIDs() (map[int]bool, error) {
+	data, err := b.request("j/clients")
+	if err != nil {
+		return nil, err
+	}
+	var clients []struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("hyprland clients: %w", err)
+	}
+	pids := make(map[int]bool, len(clients))
+	for _, c := range clients {
+		pids[c.PID] = true
+	}
+	return pids, nil
+}
+
+func (hyprlandBackend) request(cmd string) ([]byte, error) {
+	sig := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	// Hyprland moved its sockets from /tmp to the runtime dir in 0.40
+	candidates := []string{
+		filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "hypr", sig, ".socket.sock"),
+		filepath.Join("/tmp", "hypr", sig, ".socket.sock"),
+	}
+
+	var conn net.Conn
+	var err error
+	for _, path := range candidates {
+		if conn, err = net.DialTimeout("unix", path, 2*time.Second); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hyprland ipc: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return nil, fmt.Errorf("hyprland ipc write: %w", err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("hyprland ipc read: %w", err)
+	}
+	return data, nil
+}
+
+// gnomeBackend asks GNOME Shell over D-Bus. Mutter exposes no focus API of its
+// own, so this relies on the "Window Calls" shell extension, falling back to
+// Shell.Eval for sessions running in unsafe mode.
+type gnomeBackend struct {
+	mu   sync.Mutex
+	conn *dbus.Conn
+}
+
+const gnomeFocusScript = `(() => {
+	const w = global.display.focus_window;
+	return JSON.stringify(w ? {pid: w.get_pid(), title: w.get_title()} : {});
+})()`
+
+func (b *gnomeBackend) activeWindow() (int, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return 0, "", fmt.Errorf("session bus: %w", err)
+		}
+		b.conn = conn
+	}
+
+	pid, title, err := b.windowCalls()
+	if err == nil {
+		return pid, title, nil
+	}
+	if pid, title, evalErr := b.eval(); evalErr == nil {
+		return pid, title, nil
+	}
+	return 0, "", fmt.Errorf("gnome: install the Window Calls shell extension: %w", err)
+}
+
+// gnomeWindow is an entry of the Window Calls extension's window list.
+type gnomeWindow struct {
+	ID    uint32 `json:"id"`
+	PID   int    `json:"pid"`
+	Focus bool   `json:"focus"`
+	Title string `json:"title"`
+}
+
+func (b *gnomeBackend) windowCalls() (int, string, error) {
+	windows, err := b.listWindows()
+	if err != nil {
+		return 0, "", err
+	}
+	for _, w := range windows {
+		if !w.Focus {
+			continue
+		}
+		// Older extension versions only return the title on request
+		if w.Title == "" {
+			obj := b.conn.Object("org.gnome.Shell", "/org/gnome/Shell/Extensions/Windows")
+			_ = obj.Call("org.gnome.Shell.Extensions.Windows.GetTitle", 0, w.ID).Store(&w.Title)
+		}
+		return w.PID, w.Title, nil
+	}
+	return 0, "", nil
+}
+
+func (b *gnomeBackend) windowPIDs() (map[int]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil, fmt.Errorf("session bus not connected")
+	}
+	windows, err := b.listWindows()
+	if err != nil {
+		return nil, err
+	}
+	pids := make(map[int]bool, len(windows))
+	for _, w := range windows {
+		pids[w.PID] = true
+	}
+	return pids, nil
+}
+
+// listWindows must be called with b.mu held.
+func (b *gnomeBackend) listWindows() ([]gnomeWindow, error) {
+	obj := b.conn.Object("org.gnome.Shell", "/org/gnome/Shell/Extensions/Windows")
+	var list string
+	if err := obj.Call("org.gnome.Shell.Extensions.Windows.List", 0).Store(&list); err != nil {
+		return nil, err
+	}
+	var windows []gnomeWindow
+	if err := json.Unmarshal([]byte(list), &windows); err != nil {
+		return nil, fmt.Errorf("window list: %w", err)
+	}
+	return windows, nil
+}
+
+func (b *gnomeBackend) eval() (int, string, error) {
+	var ok bool
+	var result string
+	obj := b.conn.Object("org.gnome.Shell", "/org/gnome/Shell")
+	if err := obj.Call("org.gnome.Shell.Eval", 0, gnomeFocusScript).Store(&ok, &result); err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		return 0, "", fmt.Errorf("shell eval refused")
+	}
+
+	return parseGnomeEval(result)
+}
+
+// parseGnomeEval reads the focused window from gnomeFocusScript's result.
+func parseGnomeEval(result string) (int, string, error) {
+	// Eval returns the JSON string as a JS literal, so it is quoted twice
+	var inner string
+	if err := json.Unmarshal([]byte(result), &inner); err == nil {
+		result = inner
+	}
+	var win struct {
+		PID   int    `json:"pid"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal([]byte(result), &win); err != nil {
+		return 0, "", fmt.Errorf("shell eval result: %w", err)
+	}
+	return win.PID, win.Title, nil
+}
+
+// kwinBackend loads a small KWin script that calls back into the agent over
+// D-Bus whenever the active window changes. KWin has no query API for it.
+type kwinBackend struct {
+	mu     sync.Mutex
+	conn   *dbus.Conn
+	loaded bool
+	pid    int
+	title  string
+}
+
+const (
+	kwinBusName    = "nl.dazuukiknie.Agent"
+	kwinObjectPath = "/nl/dazuukiknie/Agent"
+	kwinScriptName = "dazuukiknie-agent"
+)
+
+// Plasma 6 renamed clients to windows; the script handles both. callDBus
+// sends JS numbers as doubles or ints depending on the Qt version, so the pid
+// goes as a string.
+const kwinScript = `function report(w) {
+	callDBus("` + kwinBusName + `", "` + kwinObjectPath + `", "` + kwinBusName + `", "WindowActivated",
+		w ? String(w.pid) : "0", w ? w.caption : "");
+}
+if (workspace.windowActivated) {
+	workspace.windowActivated.connect(report);
+	report(workspace.activeWindow);
+} else {
+	workspace.clientActivated.connect(report);
+	report(workspace.activeClient);
+}
+`
+
+// kwinReceiver is exported on the session bus for the KWin script to call.
+type kwinReceiver struct {
+	b *kwinBackend
+}
+
+func (r kwinReceiver) WindowActivated(pid string, caption string) *dbus.Error {
+	n, err := strconv.Atoi(pid)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	r.b.mu.Lock()
+	defer r.b.mu.Unlock()
+	r.b.pid, r.b.title = n, caption
+	return nil
+}
+
+func (b *kwinBackend) activeWindow() (int, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.loaded {
+		// KWin may not be up yet at login; retry on the next detection
+		if err := b.load(); err != nil {
+			return 0, "", fmt.Errorf("kwin script: %w", err)
+		}
+		b.loaded = true
+	}
+	return b.pid, b.title, nil
+}
+
+// load must be called with b.mu held.
+func (b *kwinBackend) load() error {
+	if b.conn == nil {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return fmt.Errorf("session bus: %w", err)
+		}
+		if err := conn.Export(kwinReceiver{b}, kwinObjectPath, kwinBusName); err != nil {
+			conn.Close()
+			return err
+		}
+		reply, err := conn.RequestName(kwinBusName, dbus.NameFlagDoNotQueue)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if reply != dbus.RequestNameReplyPrimaryOwner {
+			conn.Close()
+			return fmt.Errorf("bus name %s already taken", kwinBusName)
+		}
+		b.conn = conn
+	}
+
+	dir := dataDir()
+	_ = os.MkdirAll(dir, 0755)
+	path := filepath.Join(dir, "kwin-active-window.js")
+	if err := os.WriteFile(path, []byte(kwinScript), 0644); err != nil {
+		return err
+	}
+
+	scripting := b.conn.Object("org.kde.KWin", "/Scripting")
+	// A previous run may have left the script loaded
+	_ = scripting.Call("org.kde.kwin.Scripting.unloadScript", 0, kwinScriptName).Err
+
+	var id int32
+	if err := scripting.Call("org.kde.kwin.Scripting.loadScript", 0, path, kwinScriptName).Store(&id); err != nil {
+		return err
+	}
+	if id < 0 {
+		return fmt.Errorf("loadScript failed")
+	}
+
+	// The script object path changed in Plasma 5.27
+	var err error
+	for _, p := range []string{fmt.Sprintf("/Scripting/Script%d", id), fmt.Sprintf("/%d", id)} {
+		if err = b.conn.Object("org.kde.KWin", dbus.ObjectPath(p)).Call("org.kde.kwin.Script.run", 0).Err; err == nil {
+			return nil
+		}
+	}
+	return err
+}
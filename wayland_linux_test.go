@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serveUnix answers each connection to a unix socket at path with handle.
+func serveUnix(t *testing.T, path string, handle func(conn net.Conn)) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			handle(conn)
+			conn.Close()
+		}
+	}()
+}
+
+const swayTestTree = `{"nodes": [
+	{"nodes": [
+		{"pid": 100, "name": "Terminal", "nodes": []},
+		{"pid": 0, "nodes": [{"pid": 200, "name": "Factorio", "focused": true}]}
+	], "floating_nodes": [{"pid": 300, "name": "Steam"}]}
+]}`
+
+func TestSwayBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sway.sock")
+	serveUnix(t, path, func(conn net.Conn) {
+		req := make([]byte, 14)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			t.Errorf("read request: %v", err)
+			return
+		}
+		if string(req[:6]) != "i3-ipc" || binary.NativeEndian.Uint32(req[10:]) != swayGetTree {
+			t.Errorf("unexpected request %q", req)
+			return
+		}
+		reply := []byte("i3-ipc")
+		reply = binary.NativeEndian.AppendUint32(reply, uint32(len(swayTestTree)))
+		reply = binary.NativeEndian.AppendUint32(reply, swayGetTree)
+		conn.Write(append(reply, swayTestTree...))
+	})
+	b := swayBackend{socket: path}
+
+	pid, title, err := b.activeWindow()
+	if err != nil || pid != 200 || title != "Factorio" {
+		t.Errorf("activeWindow = %d, %q, %v; want 200, Factorio", pid, title, err)
+	}
+	pids, err := b.windowPIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pids) != 3 || !pids[100] || !pids[200] || !pids[300] {
+		t.Errorf("windowPIDs = %v, want 100, 200 and 300", pids)
+	}
+}
+
+func TestHyprlandBackend(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("HYPRLAND_INSTANCE_SIGNATURE", "abc")
+	serveUnix(t, filepath.Join(dir, "hypr", "abc", ".socket.sock"), func(conn net.Conn) {
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		switch cmd := string(buf[:n]); cmd {
+		case "j/activewindow":
+			io.WriteString(conn, `{"pid": 200, "title": "Factorio", "class": "factorio"}`)
+		case "j/clients":
+			io.WriteString(conn, `[{"pid": 100}, {"pid": 200}]`)
+		default:
+			t.Errorf("unexpected command %q", cmd)
+		}
+	})
+	var b hyprlandBackend
+
+	pid, title, err := b.activeWindow()
+	if err != nil || pid != 200 || title != "Factorio" {
+		t.Errorf("activeWindow = %d, %q, %v; want 200, Factorio", pid, title, err)
+	}
+	pids, err := b.windowPIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pids) != 2 || !pids[100] || !pids[200] {
+		t.Errorf("windowPIDs = %v, want 100 and 200", pids)
+	}
+}
+
+func TestParseGnomeEval(t *testing.T) {
+	for _, result := range []string{
+		`"{\"pid\":200,\"title\":\"Factorio\"}"`, // as returned by Shell.Eval
+		`{"pid":200,"title":"Factorio"}`,
+	} {
+		pid, title, err := parseGnomeEval(result)
+		if err != nil || pid != 200 || title != "Factorio" {
+			t.Errorf("parseGnomeEval(%s) = %d, %q, %v", result, pid, title, err)
+		}
+	}
+	if pid, _, err := parseGnomeEval(`"{}"`); err != nil || pid != 0 {
+		t.Errorf("no focused window: got %d, %v", pid, err)
+	}
+}
+
+func TestKWinReceiver(t *testing.T) {
+	if !strings.Contains(kwinScript, "String(w.pid)") {
+		t.Error("the KWin script must send the pid as a string")
+	}
+	b := &kwinBackend{}
+	r := kwinReceiver{b}
+	if err := r.WindowActivated("200", "Factorio"); err != nil {
+		t.Fatal(err)
+	}
+	if b.pid != 200 || b.title != "Factorio" {
+		t.Errorf("got %d, %q", b.pid, b.title)
+	}
+	if err := r.WindowActivated("nope", ""); err == nil {
+		t.Error("expected an error for a malformed pid")
+	}
+}
@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveExeName returns the full executable name of a process, without .exe.
+// Under Proton comm is "wine64-preloader" or a 15 character prefix like
+// "GameLauncher.e", so processes run by a Wine loader are named after the
+// Windows executable Wine puts in argv instead. Anything else keeps its own
+// name: Proton's launch wrappers (reaper, pressure-vessel, python3) carry the
+// game's .exe path in their arguments too.
+func resolveExeName(comm, exePath string, cmdline []string) string {
+	var exeName string
+	if exePath != "" {
+		exeName = filepath.Base(exePath)
+	}
+
+	if isWineLoader(comm, exeName) {
+		for _, arg := range cmdline {
+			if strings.HasSuffix(strings.ToLower(arg), ".exe") {
+				return baseNameNoExt(arg)
+			}
+		}
+	}
+
+	if exeName != "" {
+		return baseNameNoExt(exeName)
+	}
+	if len(cmdline) > 0 && cmdline[0] != "" {
+		return baseNameNoExt(cmdline[0])
+	}
+	return baseNameNoExt(comm)
+}
+
+// isWineLoader reports whether comm or the executable is Wine's loader, which
+// runs a Windows program named in argv.
+func isWineLoader(comm, exeName string) bool {
+	for _, name := range []string{comm, exeName} {
+		if strings.HasPrefix(name, "wine") || strings.HasSuffix(name, "-preloader") {
+			return true
+		}
+	}
+	return false
+}
+
+// readEnviron parses /proc/[pid]/environ. It fails for other users' processes.
+func readEnviron(pid int) (map[string]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	// environ is NUL-separated key=value pairs
+	for _, entry := range strings.Split(string(data), "\x00") {
+		if k, v, ok := strings.Cut(entry, "="); ok {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// readCmdline returns the arguments of pid from /proc/[pid]/cmdline.
+func readCmdline(pid int) []string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var args []string
+	for _, a := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+		args = append(args, string(a))
+	}
+	return args
+}
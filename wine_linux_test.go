@@ -0,0 +1,92 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestResolveExeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		comm    string
+		exe     string
+		cmdline []string
+		want    string
+	}{
+		{
+			name:    "native process",
+			comm:    "factorio",
+			exe:     "/home/me/games/factorio/bin/x64/factorio",
+			cmdline: []string{"/home/me/games/factorio/bin/x64/factorio"},
+			want:    "factorio",
+		},
+		{
+			name:    "native name longer than comm",
+			comm:    "RimWorldLinux.x",
+			exe:     "/home/me/games/RimWorld/RimWorldLinux.x86_64",
+			cmdline: []string{"./RimWorldLinux.x86_64"},
+			want:    "RimWorldLinux.x86_64",
+		},
+		{
+			name:    "proton preloader",
+			comm:    "wine64-preloader",
+			exe:     "/home/me/.steam/steam/steamapps/common/Proton 9.0/files/bin/wine64-preloader",
+			cmdline: []string{`Z:\home\me\.steam\steam\steamapps\common\Cyberpunk 2077\bin\x64\Cyberpunk2077.exe`, "--launcher-skip"},
+			want:    "Cyberpunk2077",
+		},
+		{
+			name:    "truncated comm under proton",
+			comm:    "GameLauncher.e",
+			exe:     "/home/me/.steam/steam/steamapps/common/Proton 9.0/files/bin/wine64-preloader",
+			cmdline: []string{`C:\Program Files\Game\GameLauncher.exe`},
+			want:    "GameLauncher",
+		},
+		{
+			name:    "plain wine without steam",
+			comm:    "Setup.exe",
+			exe:     "/usr/bin/wine64-preloader",
+			cmdline: []string{`C:\users\me\Downloads\Setup.EXE`},
+			want:    "Setup",
+		},
+		{
+			name:    "proton helper without exe argument",
+			comm:    "reaper",
+			exe:     "/home/me/.steam/steam/ubuntu12_32/reaper",
+			cmdline: []string{"/home/me/.steam/steam/ubuntu12_32/reaper", "SteamLaunch", "AppId=1091500"},
+			want:    "reaper",
+		},
+		{
+			name:    "reaper with the game in argv",
+			comm:    "reaper",
+			exe:     "/home/me/.steam/steam/ubuntu12_32/reaper",
+			cmdline: []string{"/home/me/.steam/steam/ubuntu12_32/reaper", "SteamLaunch", "AppId=1091500", "--", `/home/me/.steam/steam/steamapps/common/Cyberpunk 2077/bin/x64/Cyberpunk2077.exe`},
+			want:    "reaper",
+		},
+		{
+			name:    "pressure-vessel with the game in argv",
+			comm:    "pv-bwrap",
+			exe:     "/home/me/.steam/steam/steamapps/common/SteamLinuxRuntime_sniper/pressure-vessel/libexec/steam-runtime-tools-0/pv-bwrap",
+			cmdline: []string{"pv-bwrap", "--args", "42", `/home/me/.steam/steam/steamapps/common/Cyberpunk 2077/bin/x64/Cyberpunk2077.exe`},
+			want:    "pv-bwrap",
+		},
+		{
+			name:    "proton script with the game in argv",
+			comm:    "python3",
+			exe:     "/usr/bin/python3.11",
+			cmdline: []string{"python3", "/home/me/.steam/steam/steamapps/common/Proton 9.0/proton", "waitforexitandrun", `/home/me/.steam/steam/steamapps/common/Cyberpunk 2077/bin/x64/Cyberpunk2077.exe`},
+			want:    "python3.11",
+		},
+		{
+			name: "exe unreadable",
+			comm: "wineserver",
+			want: "wineserver",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveExeName(tt.comm, tt.exe, tt.cmdline); got != tt.want {
+				t.Errorf("resolveExeName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
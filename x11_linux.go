@@ -0,0 +1,182 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// x11Backend reads the EWMH properties of the focused window directly over
+// the X protocol, and watches the root window so focus changes are pushed
+// instead of waiting for the next poll.
+type x11Backend struct {
+	mu      sync.Mutex
+	conn    *xgb.Conn
+	root    xproto.Window
+	atoms   map[string]xproto.Atom
+	changes chan struct{}
+	// failures counts consecutive failed connects and queries; after
+	// x11MaxFailures the backend hands over to fallback for good.
+	failures int
+	fallback windowBackend
+}
+
+const x11MaxFailures = 3
+
+var x11AtomNames = []string{"_NET_ACTIVE_WINDOW", "_NET_CLIENT_LIST", "_NET_WM_PID", "_NET_WM_NAME", "UTF8_STRING"}
+
+func newX11Backend() (*x11Backend, error) {
+	b := &x11Backend{changes: make(chan struct{}, 1)}
+	if err := b.connect(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// connect must be called with b.mu held (or before b is shared).
+func (b *x11Backend) connect() error {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("x11 connect: %w", err)
+	}
+
+	atoms := make(map[string]xproto.Atom, len(x11AtomNames))
+	for _, name := range x11AtomNames {
+		reply, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("x11 intern %s: %w", name, err)
+		}
+		atoms[name] = reply.Atom
+	}
+
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+	err = xproto.ChangeWindowAttributesChecked(conn, root, xproto.CwEventMask,
+		[]uint32{xproto.EventMaskPropertyChange}).Check()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("x11 select events: %w", err)
+	}
+
+	b.conn, b.root, b.atoms = conn, root, atoms
+	go b.watch(conn, atoms["_NET_ACTIVE_WINDOW"])
+	return nil
+}
+
+// watch signals focus changes until the connection closes.
+func (b *x11Backend) watch(conn *xgb.Conn, activeAtom xproto.Atom) {
+	for {
+		ev, err := conn.WaitForEvent()
+		if ev == nil && err == nil {
+			return // connection closed
+		}
+		if e, ok := ev.(xproto.PropertyNotifyEvent); ok && e.Atom == activeAtom {
+			select {
+			case b.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (b *x11Backend) focusChanges() <-chan struct{} {
+	return b.changes
+}
+
+func (b *x11Backend) activeWindow() (int, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fallback != nil {
+		return b.fallback.activeWindow()
+	}
+	pid, title, err := b.query()
+	if err != nil {
+		if b.failures++; b.failures >= x11MaxFailures {
+			log.Printf("Native X11 keeps failing (%v), falling back to xdotool", err)
+			b.fallback = xdotoolBackend{}
+		}
+		return 0, "", err
+	}
+	b.failures = 0
+	return pid, title, nil
+}
+
+// query must be called with b.mu held.
+func (b *x11Backend) query() (int, string, error) {
+	if b.conn == nil {
+		if err := b.connect(); err != nil {
+			return 0, "", err
+		}
+	}
+
+	win, err := b.cardinal(b.root, b.atoms["_NET_ACTIVE_WINDOW"], xproto.AtomWindow)
+	if err != nil {
+		// The X server went away; reconnect on the next call
+		b.conn.Close()
+		b.conn = nil
+		return 0, "", fmt.Errorf("x11 active window: %w", err)
+	}
+	if win == 0 {
+		return 0, "", nil
+	}
+
+	pid, err := b.cardinal(xproto.Window(win), b.atoms["_NET_WM_PID"], xproto.AtomCardinal)
+	if err != nil {
+		return 0, "", fmt.Errorf("x11 window pid: %w", err)
+	}
+
+	title := b.text(xproto.Window(win), b.atoms["_NET_WM_NAME"], b.atoms["UTF8_STRING"])
+	if title == "" {
+		title = b.text(xproto.Window(win), xproto.AtomWmName, xproto.AtomString)
+	}
+	return int(pid), title, nil
+}
+
+func (b *x11Backend) windowPIDs() (map[int]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fallback != nil {
+		return nil, fmt.Errorf("x11 unavailable, using xdotool")
+	}
+	if b.conn == nil {
+		return nil, fmt.Errorf("x11 not connected")
+	}
+
+	reply, err := xproto.GetProperty(b.conn, false, b.root, b.atoms["_NET_CLIENT_LIST"], xproto.AtomWindow, 0, 4096).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("x11 client list: %w", err)
+	}
+	pids := make(map[int]bool)
+	for i := 0; reply.Format == 32 && i+4 <= len(reply.Value); i += 4 {
+		win := xproto.Window(xgb.Get32(reply.Value[i:]))
+		if pid, err := b.cardinal(win, b.atoms["_NET_WM_PID"], xproto.AtomCardinal); err == nil && pid > 0 {
+			pids[int(pid)] = true
+		}
+	}
+	return pids, nil
+}
+
+// cardinal reads a single 32-bit property value, 0 if unset.
+func (b *x11Backend) cardinal(win xproto.Window, prop, typ xproto.Atom) (uint32, error) {
+	reply, err := xproto.GetProperty(b.conn, false, win, prop, typ, 0, 1).Reply()
+	if err != nil {
+		return 0, err
+	}
+	if reply.Format != 32 || len(reply.Value) < 4 {
+		return 0, nil
+	}
+	return xgb.Get32(reply.Value), nil
+}
+
+func (b *x11Backend) text(win xproto.Window, prop, typ xproto.Atom) string {
+	reply, err := xproto.GetProperty(b.conn, false, win, prop, typ, 0, 1024).Reply()
+	if err != nil || reply.Format != 8 {
+		return ""
+	}
+	return string(reply.Value)
+}